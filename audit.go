@@ -0,0 +1,104 @@
+package codegenerator
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"connectrpc.com/connect"
+
+	"github.com/CGA1123/codegenerator/audit"
+	imagev1 "github.com/CGA1123/codegenerator/gen/buf/alpha/image/v1"
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+)
+
+// Auditor records the outcome of GenerateCode calls.
+type Auditor interface {
+	Audit(ctx context.Context, event *audit.Event)
+}
+
+// newAuditEvent describes the caller and contents of a GenerateCode request,
+// the outcome is filled in once it completes.
+func newAuditEvent(req *connect.Request[v1alpha1.GenerateCodeRequest]) *audit.Event {
+	event := &audit.Event{
+		Time:       time.Now(),
+		Procedure:  req.Spec().Procedure,
+		ClientIP:   clientIP(req.Peer().Addr),
+		UserAgent:  req.Header().Get("User-Agent"),
+		BufVersion: BufVersion(req.Header().Get("User-Agent")),
+		Modules:    imageModules(req.Msg.GetImage()),
+		Plugins:    make([]*audit.Plugin, len(req.Msg.GetRequests())),
+	}
+
+	for i, pluginRequest := range req.Msg.GetRequests() {
+		ref := pluginRequest.GetPluginReference()
+		event.Plugins[i] = &audit.Plugin{
+			Reference: fmt.Sprintf("%s/%s:%s", ref.GetOwner(), ref.GetName(), ref.GetVersion()),
+			Options:   strings.Join(pluginRequest.GetOptions(), ","),
+		}
+	}
+
+	return event
+}
+
+// BufVersion returns the version of the buf CLI from its User-Agent, e.g.
+// `buf/1.47.2`, or "" if the caller is not the buf CLI.
+func BufVersion(userAgent string) string {
+	for _, product := range strings.Fields(userAgent) {
+		if version, ok := strings.CutPrefix(product, "buf/"); ok {
+			return version
+		}
+	}
+
+	return ""
+}
+
+// clientIP strips the port from a peer address, unix socket peers have no
+// address.
+func clientIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+// imageModules lists the modules the files of "image" were built from, in
+// order of first appearance.
+func imageModules(image *imagev1.Image) []audit.Module {
+	var modules []audit.Module
+
+	seen := map[audit.Module]bool{}
+	for _, file := range image.GetFile() {
+		info := file.GetBufExtension().GetModuleInfo()
+		if info == nil {
+			continue
+		}
+
+		module := audit.Module{
+			Name:   moduleName(file),
+			Commit: info.GetCommit(),
+		}
+
+		if !seen[module] {
+			seen[module] = true
+			modules = append(modules, module)
+		}
+	}
+
+	return modules
+}
+
+// moduleName is the name of the module "file" is from, e.g.
+// `buf.build/acme/payments`, or "" if unknown.
+func moduleName(file *imagev1.ImageFile) string {
+	name := file.GetBufExtension().GetModuleInfo().GetName()
+	if name == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%s/%s/%s", name.GetRemote(), name.GetOwner(), name.GetRepository())
+}
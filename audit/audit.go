@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/CGA1123/codegenerator/auth"
+)
+
+// Event records a single GenerateCode call.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Procedure string    `json:"procedure"`
+
+	// Subject, Groups and Method identify the caller, and are empty for
+	// anonymous callers.
+	Subject string   `json:"subject,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+	Method  string   `json:"auth_method,omitempty"`
+
+	ClientIP   string `json:"client_ip"`
+	UserAgent  string `json:"user_agent"`
+	BufVersion string `json:"buf_version,omitempty"`
+
+	Modules []Module  `json:"modules"`
+	Plugins []*Plugin `json:"plugins"`
+
+	// Duration is written in milliseconds, as `duration_ms`.
+	Duration time.Duration `json:"-"`
+	// Code is `ok`, or the Connect code the call failed with.
+	Code  string `json:"code"`
+	Error string `json:"error,omitempty"`
+
+	// Files and Bytes total the output of all plugins.
+	Files int `json:"files"`
+	Bytes int `json:"bytes"`
+}
+
+// Module is a module the files of the request's image were built from.
+type Module struct {
+	Name   string `json:"name"`
+	Commit string `json:"commit,omitempty"`
+}
+
+// Plugin is a plugin requested by a GenerateCode call.
+type Plugin struct {
+	Reference string `json:"reference"`
+	// Version is the version which was run.
+	Version string `json:"version,omitempty"`
+	// Options is the parameter the plugin was run with, after server side
+	// configuration is applied.
+	Options string `json:"options,omitempty"`
+	Files   int    `json:"files"`
+	Bytes   int    `json:"bytes"`
+}
+
+// Logger writes Events as JSON lines.
+type Logger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewLogger builds a Logger writing to "w".
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{enc: json.NewEncoder(w)}
+}
+
+// Audit writes "event", adding the caller's identity from "ctx".
+//
+// Failures to write are logged, rather than failing the call.
+func (l *Logger) Audit(ctx context.Context, event *Event) {
+	if id := auth.FromContext(ctx); id != nil {
+		event.Subject = id.Subject
+		event.Groups = id.Groups
+		event.Method = id.Method
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.enc.Encode(struct {
+		*Event
+		DurationMS float64 `json:"duration_ms"`
+	}{
+		Event:      event,
+		DurationMS: float64(event.Duration) / float64(time.Millisecond),
+	})
+	if err != nil {
+		slog.Error("writing audit log", "error", err)
+	}
+}
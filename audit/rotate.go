@@ -0,0 +1,166 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupFormat timestamps rotated files, sorting in the order they were
+// rotated.
+const backupFormat = "20060102T150405.000000000"
+
+// RotatingFile appends to a file, which is rotated once it would grow beyond
+// a maximum size.
+//
+// Rotated files are renamed to `<path>.<timestamp>`, and only the newest
+// backups are kept.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	now        func() time.Time
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the file at "path" for appending, rotating it once
+// it reaches "maxSize" bytes and keeping "maxBackups" rotated files. A
+// "maxSize" of 0 disables rotation, and a "maxBackups" of 0 keeps all
+// rotated files.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups, now: time.Now}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("creating log directory: %w", err)
+	}
+
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("opening log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("stating log file: %w", err)
+	}
+
+	r.file = f
+	r.size = info.Size()
+
+	return nil
+}
+
+// Write appends "p" to the file, rotating it first if "p" would take it
+// beyond the maximum size.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+// Close closes the file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return r.reopen(fmt.Errorf("closing log file: %w", err))
+	}
+
+	backup := r.path + "." + r.now().UTC().Format(backupFormat)
+	if err := os.Rename(r.path, backup); err != nil {
+		return r.reopen(fmt.Errorf("rotating log file: %w", err))
+	}
+
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	if r.maxBackups == 0 {
+		return nil
+	}
+
+	backups, err := r.backups()
+	if err != nil {
+		return fmt.Errorf("listing rotated log files: %w", err)
+	}
+
+	for len(backups) > r.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			return fmt.Errorf("removing rotated log file: %w", err)
+		}
+
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// backups lists the rotated files, oldest first. Other files sharing the
+// file's name as a prefix, e.g. compressed backups, are left alone.
+func (r *RotatingFile) backups() ([]string, error) {
+	dir, prefix := filepath.Dir(r.path), filepath.Base(r.path)+"."
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		stamp, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+
+		if _, err := time.Parse(backupFormat, stamp); err != nil {
+			continue
+		}
+
+		backups = append(backups, filepath.Join(dir, entry.Name()))
+	}
+
+	slices.Sort(backups)
+
+	return backups, nil
+}
+
+// reopen reopens the file after a failed rotation, so that later writes,
+// which retry the rotation, are not made to a closed file. It returns "err".
+func (r *RotatingFile) reopen(err error) error {
+	if openErr := r.open(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+
+	return err
+}
@@ -0,0 +1,169 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openRotating opens a RotatingFile whose clock moves a second on for every
+// rotation, so backups have distinct, predictable names.
+func openRotating(t *testing.T, maxSize int64, maxBackups int) (*RotatingFile, string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "logs", "audit.log")
+
+	r, err := OpenRotatingFile(path, maxSize, maxBackups)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error = %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	now := time.Unix(1_700_000_000, 0)
+	r.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	return r, path
+}
+
+func write(t *testing.T, r *RotatingFile, lines ...string) {
+	t.Helper()
+
+	for _, line := range lines {
+		if _, err := r.Write([]byte(line + "\n")); err != nil {
+			t.Fatalf("Write(%q) error = %v", line, err)
+		}
+	}
+}
+
+// backup is the name of the file rotated at "sec" seconds after the start of
+// the clock of openRotating.
+func backup(path string, sec int64) string {
+	return path + "." + time.Unix(1_700_000_000+sec, 0).UTC().Format(backupFormat)
+}
+
+func read(t *testing.T, path string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestRotatingFileRotates(t *testing.T) {
+	r, path := openRotating(t, 10, 0)
+
+	// Writes fill the file up to its maximum size, but are never split,
+	// and a write larger than the maximum goes to an empty file.
+	write(t, r, "aaaa", "bbbb", "cccc", "dddddddddddddddd", "eeee")
+
+	for file, want := range map[string]string{
+		backup(path, 1): "aaaa\nbbbb\n",
+		backup(path, 2): "cccc\n",
+		backup(path, 3): "dddddddddddddddd\n",
+		path:            "eeee\n",
+	} {
+		if got := read(t, file); got != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, want)
+		}
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	r, path := openRotating(t, 10, 0)
+	write(t, r, "aaaa")
+	r.Close()
+
+	// Reopening appends to the existing file, counting its size.
+	r, err := OpenRotatingFile(path, 10, 0)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error = %v", err)
+	}
+	defer r.Close()
+
+	write(t, r, "bbbb", "cccc")
+
+	if got := read(t, path); got != "cccc\n" {
+		t.Fatalf("audit.log = %q, want the file rotated", got)
+	}
+
+	backups, err := r.backups()
+	if err != nil || len(backups) != 1 || read(t, backups[0]) != "aaaa\nbbbb\n" {
+		t.Fatalf("backups = %v, %v, want one of aaaa and bbbb", backups, err)
+	}
+}
+
+func TestRotatingFilePrunes(t *testing.T) {
+	r, path := openRotating(t, 5, 2)
+
+	// Files which are not backups are never removed.
+	others := []string{path + ".gz", path + ".old", path + ".20060102", path + ".19700101T000000.000000000.gz"}
+	for _, other := range others {
+		if err := os.WriteFile(other, nil, 0o640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(t, r, "aaaa", "bbbb", "cccc", "dddd", "eeee")
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, entry := range entries {
+		got = append(got, filepath.Join(filepath.Dir(path), entry.Name()))
+	}
+
+	want := append([]string{path, backup(path, 3), backup(path, 4)}, others...)
+	slices.Sort(got)
+	slices.Sort(want)
+
+	if !slices.Equal(got, want) {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+
+	if got := read(t, backup(path, 4)); got != "dddd\n" {
+		t.Fatalf("newest backup = %q, want dddd", got)
+	}
+}
+
+func TestRotatingFileReopensOnFailure(t *testing.T) {
+	r, path := openRotating(t, 5, 0)
+	write(t, r, "aaaa")
+
+	// A directory in the way of the backup fails the rotation.
+	blocker := backup(path, 1)
+	if err := os.MkdirAll(filepath.Join(blocker, "x"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Write([]byte("bbbb\n")); err == nil || !strings.Contains(err.Error(), "rotating log file") {
+		t.Fatalf("Write() error = %v, want the rotation to fail", err)
+	}
+
+	if err := os.RemoveAll(blocker); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is still open, and the rotation is retried by the next
+	// write.
+	write(t, r, "cccc")
+
+	if got := read(t, backup(path, 2)); got != "aaaa\n" {
+		t.Fatalf("backup = %q, want aaaa", got)
+	}
+
+	if got := read(t, path); got != "cccc\n" {
+		t.Fatalf("audit.log = %q, want cccc", got)
+	}
+}
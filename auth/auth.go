@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"connectrpc.com/connect"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request carries
+// no credentials at all, and wrapped when it carries credentials the
+// Authenticator does not recognise, allowing another Authenticator to try.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Identity is an authenticated caller.
+type Identity struct {
+	// Subject identifies the caller, e.g. a user or service account name.
+	Subject string
+	// Groups are used to grant access to groups of callers.
+	Groups []string
+	// Method is the method the caller authenticated with.
+	Method string
+}
+
+func (i *Identity) String() string {
+	return fmt.Sprintf("%s:%s", i.Method, i.Subject)
+}
+
+// Authenticator identifies the caller of a request.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+// Chain tries each Authenticator in order, until one recognises the
+// credentials of the request.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (*Identity, error) {
+	err := ErrNoCredentials
+	for _, a := range c {
+		id, authErr := a.Authenticate(r)
+		if errors.Is(authErr, ErrNoCredentials) {
+			// Prefer reporting why presented credentials were not
+			// recognised over there being none.
+			if authErr != ErrNoCredentials {
+				err = authErr
+			}
+
+			continue
+		}
+
+		return id, authErr
+	}
+
+	return nil, err
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of "ctx" carrying "id".
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// FromContext returns the identity of the caller, or nil for anonymous
+// callers.
+func FromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+
+	return id
+}
+
+// Middleware authenticates requests before passing them to "next", with the
+// caller's Identity available via FromContext.
+//
+// Requests which fail to authenticate are rejected with a Connect
+// `unauthenticated` error. Requests without any credentials are rejected
+// unless "anonymous" is set, in which case they are passed on without an
+// Identity.
+func Middleware(authn Authenticator, anonymous bool, next http.Handler) http.Handler {
+	errw := connect.NewErrorWriter()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := authn.Authenticate(r)
+		if err != nil {
+			if anonymous && err == ErrNoCredentials {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			_ = errw.Write(w, r, connect.NewError(connect.CodeUnauthenticated, err))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithIdentity(r.Context(), id)))
+	})
+}
+
+// bearerToken extracts the bearer token from the Authorization header, as
+// sent by the buf CLI for credentials from `buf registry login` or netrc.
+func bearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", ErrNoCredentials
+	}
+
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", fmt.Errorf("%w: unsupported authorization scheme", ErrNoCredentials)
+	}
+
+	return token, nil
+}
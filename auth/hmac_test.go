@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHMACTokens(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	current, previous := []byte("current-secret"), []byte("previous-secret")
+
+	h := NewHMACTokens(current, previous)
+	h.now = func() time.Time { return now }
+
+	// token signs "claims" with "secret", as HMACTokens.Sign would.
+	token := func(secret []byte, claims any) string {
+		b, err := json.Marshal(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		payload := base64.RawURLEncoding.EncodeToString(b)
+
+		return payload + "." + base64.RawURLEncoding.EncodeToString(sign(secret, payload))
+	}
+
+	valid := map[string]any{"sub": "alice", "groups": []string{"devs"}, "exp": now.Add(time.Hour).Unix()}
+
+	tests := []struct {
+		name  string
+		token string
+		// wantErr is a substring of the error, if one is expected.
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			token: token(current, valid),
+		},
+		{
+			name:  "rotated secret",
+			token: token(previous, valid),
+		},
+		{
+			name:    "unknown secret",
+			token:   token([]byte("other-secret"), valid),
+			wantErr: "invalid token signature",
+		},
+		{
+			name: "tampered signature",
+			token: func() string {
+				payload, sig, _ := strings.Cut(token(current, valid), ".")
+				b, _ := base64.RawURLEncoding.DecodeString(sig)
+				b[0] ^= 0xff
+
+				return payload + "." + base64.RawURLEncoding.EncodeToString(b)
+			}(),
+			wantErr: "invalid token signature",
+		},
+		{
+			name: "tampered payload",
+			token: func() string {
+				_, sig, _ := strings.Cut(token(current, valid), ".")
+				payload, _, _ := strings.Cut(token(current, map[string]any{"sub": "mallory", "exp": now.Add(time.Hour).Unix()}), ".")
+
+				return payload + "." + sig
+			}(),
+			wantErr: "invalid token signature",
+		},
+		{
+			name:    "empty signature",
+			token:   strings.Split(token(current, valid), ".")[0] + ".",
+			wantErr: "invalid token signature",
+		},
+		{
+			name:    "expired",
+			token:   token(current, map[string]any{"sub": "alice", "exp": now.Add(-time.Second).Unix()}),
+			wantErr: "token has expired",
+		},
+		{
+			name:    "expiring now",
+			token:   token(current, map[string]any{"sub": "alice", "exp": now.Unix()}),
+			wantErr: "token has expired",
+		},
+		{
+			name:    "no expiry",
+			token:   token(current, map[string]any{"sub": "alice"}),
+			wantErr: "token has expired",
+		},
+		{
+			name:    "expiry not a timestamp",
+			token:   token(current, map[string]any{"sub": "alice", "exp": "2099-01-01T00:00:00Z"}),
+			wantErr: "decoding token",
+		},
+		{
+			name:    "fractional expiry",
+			token:   token(current, map[string]any{"sub": "alice", "exp": float64(now.Add(time.Hour).Unix()) + 0.5}),
+			wantErr: "decoding token",
+		},
+		{
+			name:    "no subject",
+			token:   token(current, map[string]any{"exp": now.Add(time.Hour).Unix()}),
+			wantErr: "token has no subject",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := h.Authenticate(bearer(tt.token))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Authenticate() error = %v, want %q", err, tt.wantErr)
+				}
+
+				if errors.Is(err, ErrNoCredentials) {
+					t.Fatalf("Authenticate() error = %v, want the token to be rejected", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+
+			if id.Subject != "alice" || id.Method != "hmac" || !slices.Equal(id.Groups, []string{"devs"}) {
+				t.Fatalf("Authenticate() = %+v", id)
+			}
+		})
+	}
+}
+
+func TestHMACTokensSign(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	h := NewHMACTokens([]byte("current-secret"))
+	h.now = func() time.Time { return now }
+
+	token, err := h.Sign(Identity{Subject: "alice", Groups: []string{"devs"}}, time.Minute)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	if _, err := h.Authenticate(bearer(token)); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	// Tokens are not valid once their TTL has passed, and other issuers'
+	// clocks are not allowed for.
+	h.now = func() time.Time { return now.Add(time.Minute) }
+	if _, err := h.Authenticate(bearer(token)); err == nil {
+		t.Fatal("Authenticate() error = nil, want the token to have expired")
+	}
+
+	if _, err := NewHMACTokens().Sign(Identity{Subject: "alice"}, time.Minute); err == nil {
+		t.Fatal("Sign() error = nil without a secret, want an error")
+	}
+}
+
+func TestHMACTokensNotAToken(t *testing.T) {
+	h := NewHMACTokens([]byte("current-secret"))
+
+	for _, token := range []string{"", "opaque", "a.b.c", "e30.!!!"} {
+		if _, err := h.Authenticate(bearer(token)); !errors.Is(err, ErrNoCredentials) {
+			t.Errorf("Authenticate(%q) error = %v, want ErrNoCredentials", token, err)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// JWTConfig configures validation of JWTs.
+type JWTConfig struct {
+	// JWKSFile is a JSON Web Key Set containing the keys tokens may be
+	// signed with.
+	JWKSFile string
+	// Issuer, if set, must match the `iss` claim.
+	Issuer string
+	// Audience, if set, must be contained in the `aud` claim.
+	Audience string
+	// SubjectClaim is the claim identifying the caller, defaulting to `sub`.
+	SubjectClaim string
+	// GroupsClaim is the claim listing the caller's groups, defaulting to
+	// `groups`.
+	GroupsClaim string
+}
+
+// JWT authenticates callers with JWTs signed by one of the keys in a local
+// JWKS file. RSA (RS256, RS384, RS512), ECDSA (ES256, ES384, ES512) and
+// Ed25519 (EdDSA) signatures are supported.
+type JWT struct {
+	config JWTConfig
+	keys   []jwk
+	now    func() time.Time
+}
+
+// leeway allows for clock skew between the issuer and the server.
+const leeway = time.Minute
+
+type jwk struct {
+	kid string
+	alg string
+	key crypto.PublicKey
+}
+
+// NewJWT builds a JWT authenticator, reading the keys from the JWKS file.
+func NewJWT(config JWTConfig) (*JWT, error) {
+	if config.SubjectClaim == "" {
+		config.SubjectClaim = "sub"
+	}
+
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+
+	b, err := os.ReadFile(config.JWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading jwks: %w", err)
+	}
+
+	keys, err := parseJWKS(b)
+	if err != nil {
+		return nil, fmt.Errorf("parsing jwks %s: %w", config.JWKSFile, err)
+	}
+
+	return &JWT{config: config, keys: keys, now: time.Now}, nil
+}
+
+func (j *JWT) Authenticate(r *http.Request) (*Identity, error) {
+	token, err := bearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a jwt", ErrNoCredentials)
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: not a jwt", ErrNoCredentials)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding jwt signature: %w", err)
+	}
+
+	if err := j.verify(header.Alg, header.Kid, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	claims := map[string]any{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("decoding jwt claims: %w", err)
+	}
+
+	if err := j.validate(claims); err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims[j.config.SubjectClaim].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("jwt has no %q claim", j.config.SubjectClaim)
+	}
+
+	id := &Identity{Subject: subject, Method: "jwt"}
+	switch groups := claims[j.config.GroupsClaim].(type) {
+	case string:
+		id.Groups = []string{groups}
+	case []any:
+		for _, g := range groups {
+			if g, ok := g.(string); ok {
+				id.Groups = append(id.Groups, g)
+			}
+		}
+	}
+
+	return id, nil
+}
+
+func (j *JWT) verify(alg, kid, signed string, signature []byte) error {
+	hash, ok := map[string]crypto.Hash{
+		"RS256": crypto.SHA256,
+		"RS384": crypto.SHA384,
+		"RS512": crypto.SHA512,
+		"ES256": crypto.SHA256,
+		"ES384": crypto.SHA384,
+		"ES512": crypto.SHA512,
+		"EdDSA": 0,
+	}[alg]
+	if !ok {
+		return fmt.Errorf("unsupported jwt algorithm %q", alg)
+	}
+
+	var digest []byte
+	if hash != 0 {
+		h := hash.New()
+		h.Write([]byte(signed))
+		digest = h.Sum(nil)
+	}
+
+	for _, k := range j.keys {
+		if (kid != "" && k.kid != kid) || (k.alg != "" && k.alg != alg) {
+			continue
+		}
+
+		var valid bool
+		switch key := k.key.(type) {
+		case *rsa.PublicKey:
+			valid = strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil
+		case *ecdsa.PublicKey:
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if strings.HasPrefix(alg, "ES") && len(signature) == 2*size {
+				r := new(big.Int).SetBytes(signature[:size])
+				s := new(big.Int).SetBytes(signature[size:])
+				valid = ecdsa.Verify(key, digest, r, s)
+			}
+		case ed25519.PublicKey:
+			valid = alg == "EdDSA" && ed25519.Verify(key, []byte(signed), signature)
+		}
+
+		if valid {
+			return nil
+		}
+	}
+
+	return errors.New("invalid jwt signature")
+}
+
+func (j *JWT) validate(claims map[string]any) error {
+	now := j.now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("jwt has no exp claim")
+	}
+
+	if now.Add(-leeway).After(time.Unix(int64(exp), 0)) {
+		return errors.New("jwt has expired")
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("jwt is not valid yet")
+	}
+
+	if j.config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != j.config.Issuer {
+			return fmt.Errorf("jwt issuer %q is not trusted", iss)
+		}
+	}
+
+	if j.config.Audience != "" {
+		var audience []string
+		switch aud := claims["aud"].(type) {
+		case string:
+			audience = []string{aud}
+		case []any:
+			for _, a := range aud {
+				if a, ok := a.(string); ok {
+					audience = append(audience, a)
+				}
+			}
+		}
+
+		if !slices.Contains(audience, j.config.Audience) {
+			return fmt.Errorf("jwt audience does not include %q", j.config.Audience)
+		}
+	}
+
+	return nil
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func parseJWKS(b []byte) ([]jwk, error) {
+	set := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}{}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []jwk
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key crypto.PublicKey
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeBigInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("keys[%d].n: %w", i, err)
+			}
+
+			e, err := decodeBigInt(k.E)
+			if err != nil {
+				return nil, fmt.Errorf("keys[%d].e: %w", i, err)
+			}
+
+			key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			curve, ok := map[string]elliptic.Curve{
+				"P-256": elliptic.P256(),
+				"P-384": elliptic.P384(),
+				"P-521": elliptic.P521(),
+			}[k.Crv]
+			if !ok {
+				return nil, fmt.Errorf("keys[%d]: unsupported curve %q", i, k.Crv)
+			}
+
+			x, err := decodeBigInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("keys[%d].x: %w", i, err)
+			}
+
+			y, err := decodeBigInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("keys[%d].y: %w", i, err)
+			}
+
+			key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		case "OKP":
+			if k.Crv != "Ed25519" {
+				return nil, fmt.Errorf("keys[%d]: unsupported curve %q", i, k.Crv)
+			}
+
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("keys[%d].x: invalid ed25519 key", i)
+			}
+
+			key = ed25519.PublicKey(x)
+		default:
+			return nil, fmt.Errorf("keys[%d]: unsupported key type %q", i, k.Kty)
+		}
+
+		keys = append(keys, jwk{kid: k.Kid, alg: k.Alg, key: key})
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys found")
+	}
+
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,418 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+type jwtKeys struct {
+	rsa     *rsa.PrivateKey
+	ecdsa   *ecdsa.PrivateKey
+	ed25519 ed25519.PrivateKey
+}
+
+func newJWTKeys(t *testing.T) *jwtKeys {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &jwtKeys{rsa: rsaKey, ecdsa: ecKey, ed25519: edKey}
+}
+
+// jwks writes the public keys as a JWKS file, returning its path.
+func (k *jwtKeys) jwks(t *testing.T) string {
+	t.Helper()
+
+	enc := base64.RawURLEncoding.EncodeToString
+	set := map[string]any{"keys": []map[string]string{
+		{
+			"kty": "RSA", "kid": "rsa", "alg": "RS256", "use": "sig",
+			"n": enc(k.rsa.N.Bytes()),
+			"e": enc(big.NewInt(int64(k.rsa.E)).Bytes()),
+		},
+		{
+			"kty": "EC", "kid": "ec", "crv": "P-256",
+			"x": enc(k.ecdsa.X.FillBytes(make([]byte, 32))),
+			"y": enc(k.ecdsa.Y.FillBytes(make([]byte, 32))),
+		},
+		{
+			"kty": "OKP", "kid": "ed", "crv": "Ed25519",
+			"x": enc(k.ed25519.Public().(ed25519.PublicKey)),
+		},
+	}}
+
+	b, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+// signer signs the `<header>.<claims>` of a JWT.
+type signer func(t *testing.T, signed string) []byte
+
+func (k *jwtKeys) rs256(t *testing.T, signed string) []byte {
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+func (k *jwtKeys) es256(t *testing.T, signed string) []byte {
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, k.ecdsa, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+}
+
+func (k *jwtKeys) eddsa(_ *testing.T, signed string) []byte {
+	return ed25519.Sign(k.ed25519, []byte(signed))
+}
+
+// hs256 signs with the RSA public key as a HMAC secret, as an attacker
+// confusing the algorithm of a public key would.
+func (k *jwtKeys) hs256(t *testing.T, signed string) []byte {
+	secret, err := x509.MarshalPKIXPublicKey(&k.rsa.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signed))
+
+	return mac.Sum(nil)
+}
+
+func none(*testing.T, string) []byte { return nil }
+
+func encodeJWT(t *testing.T, header, claims map[string]any, sign signer) string {
+	t.Helper()
+
+	segment := func(v any) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	signed := segment(header) + "." + segment(claims)
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(t, signed))
+}
+
+func bearer(token string) *http.Request {
+	r, _ := http.NewRequest(http.MethodPost, "http://localhost", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return r
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	keys := newJWTKeys(t)
+	now := time.Unix(1_700_000_000, 0)
+
+	j, err := NewJWT(JWTConfig{JWKSFile: keys.jwks(t), Issuer: "https://issuer.example", Audience: "codegenerator"})
+	if err != nil {
+		t.Fatalf("NewJWT() error = %v", err)
+	}
+	j.now = func() time.Time { return now }
+
+	claims := func(set map[string]any) map[string]any {
+		c := map[string]any{
+			"sub":    "alice",
+			"groups": []string{"admins", "devs"},
+			"iss":    "https://issuer.example",
+			"aud":    "codegenerator",
+			"exp":    now.Add(time.Hour).Unix(),
+		}
+		for k, v := range set {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		header map[string]any
+		claims map[string]any
+		sign   signer
+		// tamper modifies the encoded token, if set.
+		tamper func(token string) string
+		// wantErr is a substring of the error, if one is expected.
+		wantErr string
+	}{
+		{
+			name:   "rs256",
+			header: map[string]any{"alg": "RS256", "kid": "rsa"},
+			claims: claims(nil),
+			sign:   keys.rs256,
+		},
+		{
+			name:   "es256",
+			header: map[string]any{"alg": "ES256", "kid": "ec"},
+			claims: claims(nil),
+			sign:   keys.es256,
+		},
+		{
+			name:   "eddsa without kid",
+			header: map[string]any{"alg": "EdDSA"},
+			claims: claims(nil),
+			sign:   keys.eddsa,
+		},
+		{
+			name:   "audience list",
+			header: map[string]any{"alg": "RS256", "kid": "rsa"},
+			claims: claims(map[string]any{"aud": []string{"other", "codegenerator"}}),
+			sign:   keys.rs256,
+		},
+		{
+			name:   "expired within leeway",
+			header: map[string]any{"alg": "RS256", "kid": "rsa"},
+			claims: claims(map[string]any{"exp": now.Add(-leeway / 2).Unix()}),
+			sign:   keys.rs256,
+		},
+		{
+			name:   "not yet valid within leeway",
+			header: map[string]any{"alg": "RS256", "kid": "rsa"},
+			claims: claims(map[string]any{"nbf": now.Add(leeway / 2).Unix()}),
+			sign:   keys.rs256,
+		},
+		{
+			name:    "alg none",
+			header:  map[string]any{"alg": "none"},
+			claims:  claims(nil),
+			sign:    none,
+			wantErr: "unsupported jwt algorithm",
+		},
+		{
+			name:    "alg none with kid",
+			header:  map[string]any{"alg": "none", "kid": "rsa"},
+			claims:  claims(nil),
+			sign:    none,
+			wantErr: "unsupported jwt algorithm",
+		},
+		{
+			name:    "hs256 with public key as secret",
+			header:  map[string]any{"alg": "HS256", "kid": "rsa"},
+			claims:  claims(nil),
+			sign:    keys.hs256,
+			wantErr: "unsupported jwt algorithm",
+		},
+		{
+			name:    "alg not matching the key alg",
+			header:  map[string]any{"alg": "RS512", "kid": "rsa"},
+			claims:  claims(nil),
+			sign:    keys.rs256,
+			wantErr: "invalid jwt signature",
+		},
+		{
+			name:    "alg not matching the key type",
+			header:  map[string]any{"alg": "RS256", "kid": "ec"},
+			claims:  claims(nil),
+			sign:    keys.es256,
+			wantErr: "invalid jwt signature",
+		},
+		{
+			name:    "eddsa signature as es256",
+			header:  map[string]any{"alg": "ES256", "kid": "ed"},
+			claims:  claims(nil),
+			sign:    keys.eddsa,
+			wantErr: "invalid jwt signature",
+		},
+		{
+			name:    "unknown kid",
+			header:  map[string]any{"alg": "RS256", "kid": "other"},
+			claims:  claims(nil),
+			sign:    keys.rs256,
+			wantErr: "invalid jwt signature",
+		},
+		{
+			name:   "tampered signature",
+			header: map[string]any{"alg": "RS256", "kid": "rsa"},
+			claims: claims(nil),
+			sign:   keys.rs256,
+			tamper: func(token string) string {
+				i := strings.LastIndex(token, ".") + 1
+				sig, _ := base64.RawURLEncoding.DecodeString(token[i:])
+				sig[0] ^= 0xff
+
+				return token[:i] + base64.RawURLEncoding.EncodeToString(sig)
+			},
+			wantErr: "invalid jwt signature",
+		},
+		{
+			name:   "tampered claims",
+			header: map[string]any{"alg": "ES256", "kid": "ec"},
+			claims: claims(nil),
+			sign:   keys.es256,
+			tamper: func(token string) string {
+				parts := strings.Split(token, ".")
+				b, _ := json.Marshal(claims(map[string]any{"sub": "mallory"}))
+				parts[1] = base64.RawURLEncoding.EncodeToString(b)
+
+				return strings.Join(parts, ".")
+			},
+			wantErr: "invalid jwt signature",
+		},
+		{
+			name:    "expired",
+			header:  map[string]any{"alg": "RS256", "kid": "rsa"},
+			claims:  claims(map[string]any{"exp": now.Add(-2 * leeway).Unix()}),
+			sign:    keys.rs256,
+			wantErr: "jwt has expired",
+		},
+		{
+			name:    "no expiry",
+			header:  map[string]any{"alg": "RS256", "kid": "rsa"},
+			claims:  claims(map[string]any{"exp": nil}),
+			sign:    keys.rs256,
+			wantErr: "jwt has no exp claim",
+		},
+		{
+			name:    "expiry not a number",
+			header:  map[string]any{"alg": "RS256", "kid": "rsa"},
+			claims:  claims(map[string]any{"exp": "tomorrow"}),
+			sign:    keys.rs256,
+			wantErr: "jwt has no exp claim",
+		},
+		{
+			name:    "not yet valid",
+			header:  map[string]any{"alg": "RS256", "kid": "rsa"},
+			claims:  claims(map[string]any{"nbf": now.Add(2 * leeway).Unix()}),
+			sign:    keys.rs256,
+			wantErr: "jwt is not valid yet",
+		},
+		{
+			name:    "wrong issuer",
+			header:  map[string]any{"alg": "RS256", "kid": "rsa"},
+			claims:  claims(map[string]any{"iss": "https://evil.example"}),
+			sign:    keys.rs256,
+			wantErr: "jwt issuer",
+		},
+		{
+			name:    "no issuer",
+			header:  map[string]any{"alg": "RS256", "kid": "rsa"},
+			claims:  claims(map[string]any{"iss": nil}),
+			sign:    keys.rs256,
+			wantErr: "jwt issuer",
+		},
+		{
+			name:    "wrong audience",
+			header:  map[string]any{"alg": "RS256", "kid": "rsa"},
+			claims:  claims(map[string]any{"aud": []string{"other"}}),
+			sign:    keys.rs256,
+			wantErr: "jwt audience",
+		},
+		{
+			name:    "no audience",
+			header:  map[string]any{"alg": "RS256", "kid": "rsa"},
+			claims:  claims(map[string]any{"aud": nil}),
+			sign:    keys.rs256,
+			wantErr: "jwt audience",
+		},
+		{
+			name:    "no subject",
+			header:  map[string]any{"alg": "RS256", "kid": "rsa"},
+			claims:  claims(map[string]any{"sub": nil}),
+			sign:    keys.rs256,
+			wantErr: `jwt has no "sub" claim`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := encodeJWT(t, tt.header, tt.claims, tt.sign)
+			if tt.tamper != nil {
+				token = tt.tamper(token)
+			}
+
+			id, err := j.Authenticate(bearer(token))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Authenticate() error = %v, want %q", err, tt.wantErr)
+				}
+
+				if errors.Is(err, ErrNoCredentials) {
+					t.Fatalf("Authenticate() error = %v, want the token to be rejected", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Authenticate() error = %v", err)
+			}
+
+			if id.Subject != "alice" || id.Method != "jwt" || !slices.Equal(id.Groups, []string{"admins", "devs"}) {
+				t.Fatalf("Authenticate() = %+v", id)
+			}
+		})
+	}
+}
+
+func TestJWTAuthenticateNotAJWT(t *testing.T) {
+	keys := newJWTKeys(t)
+
+	j, err := NewJWT(JWTConfig{JWKSFile: keys.jwks(t)})
+	if err != nil {
+		t.Fatalf("NewJWT() error = %v", err)
+	}
+
+	for _, token := range []string{"", "opaque", "a.b", "!!!.e30.", "a.b.c.d"} {
+		if _, err := j.Authenticate(bearer(token)); !errors.Is(err, ErrNoCredentials) {
+			t.Errorf("Authenticate(%q) error = %v, want ErrNoCredentials", token, err)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ClientCertificates authenticates callers by the TLS client certificate
+// they presented, which must have been verified during the handshake.
+//
+// The subject is taken from the certificate according to Subject, which is
+// one of "uri", "dns", "email" or "cn". If empty, the first of a URI SAN (e.g.
+// a SPIFFE ID), DNS SAN, email SAN or the common name is used. The
+// certificate's organizational units are used as groups.
+type ClientCertificates struct {
+	Subject string
+}
+
+func (c *ClientCertificates) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	if len(r.TLS.VerifiedChains) == 0 {
+		return nil, errors.New("client certificate was not verified")
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+
+	var candidates []string
+	switch c.Subject {
+	case "uri":
+		for _, uri := range cert.URIs {
+			candidates = append(candidates, uri.String())
+		}
+	case "dns":
+		candidates = cert.DNSNames
+	case "email":
+		candidates = cert.EmailAddresses
+	case "cn":
+		candidates = []string{cert.Subject.CommonName}
+	case "":
+		for _, uri := range cert.URIs {
+			candidates = append(candidates, uri.String())
+		}
+		candidates = append(candidates, cert.DNSNames...)
+		candidates = append(candidates, cert.EmailAddresses...)
+		candidates = append(candidates, cert.Subject.CommonName)
+	default:
+		return nil, fmt.Errorf("unsupported client certificate subject %q", c.Subject)
+	}
+
+	for _, subject := range candidates {
+		if subject != "" {
+			return &Identity{Subject: subject, Groups: cert.Subject.OrganizationalUnit, Method: "mtls"}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("client certificate %q has no %s subject", cert.Subject, c.Subject)
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"sync/atomic"
+
+	"connectrpc.com/connect"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+)
+
+// Rule grants callers access to plugins.
+//
+// A rule applies to callers whose subject is in Subjects, or who are a member
+// of one of Groups, a subject of "*" matches any authenticated caller and a
+// rule with neither applies to all callers, including anonymous ones.
+//
+// Owners, Plugins and Versions are path.Match patterns, an empty list matches
+// anything.
+type Rule struct {
+	Subjects []string
+	Groups   []string
+	Owners   []string
+	Plugins  []string
+	Versions []string
+}
+
+func (r *Rule) appliesTo(id *Identity) bool {
+	if len(r.Subjects) == 0 && len(r.Groups) == 0 {
+		return true
+	}
+
+	if id == nil {
+		return false
+	}
+
+	if slices.Contains(r.Subjects, "*") || slices.Contains(r.Subjects, id.Subject) {
+		return true
+	}
+
+	for _, g := range id.Groups {
+		if slices.Contains(r.Groups, g) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Rule) allows(ref *v1alpha1.CuratedPluginReference) bool {
+	return matchAny(r.Owners, ref.GetOwner()) &&
+		matchAny(r.Plugins, ref.GetName()) &&
+		matchAny(r.Versions, ref.GetVersion())
+}
+
+func matchAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Policy decides which plugins callers may use, denying anything not
+// granted by one of its rules.
+//
+// The rules can be replaced while the server is running with Store.
+type Policy struct {
+	rules atomic.Pointer[[]Rule]
+}
+
+// NewPolicy builds a Policy from "rules".
+func NewPolicy(rules []Rule) *Policy {
+	p := &Policy{}
+	p.Store(rules)
+
+	return p
+}
+
+// Store replaces the rules of the policy.
+func (p *Policy) Store(rules []Rule) {
+	p.rules.Store(&rules)
+}
+
+// Authorize returns a `permission_denied` error unless the caller in "ctx" is
+// allowed to use the plugin.
+func (p *Policy) Authorize(ctx context.Context, ref *v1alpha1.CuratedPluginReference) error {
+	id := FromContext(ctx)
+
+	for _, rule := range *p.rules.Load() {
+		if rule.appliesTo(id) && rule.allows(ref) {
+			return nil
+		}
+	}
+
+	caller := "anonymous caller"
+	if id != nil {
+		caller = id.String()
+	}
+
+	return connect.NewError(
+		connect.CodePermissionDenied,
+		fmt.Errorf("%s is not allowed to use plugin '%s/%s:%s'", caller, ref.GetOwner(), ref.GetName(), ref.GetVersion()),
+	)
+}
@@ -0,0 +1,183 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Dev is a local development certificate authority and a server certificate
+// signed by it.
+type Dev struct {
+	CACert string
+	Cert   string
+	Key    string
+}
+
+// GenerateDev writes a development CA (`codegenerator-ca.crt`) and a server
+// certificate (`codegenerator.crt` and `codegenerator.key`) for "hosts" into
+// "dir".
+//
+// An existing CA in "dir" is reused, so it only needs to be trusted once,
+// while the server certificate is always reissued.
+func GenerateDev(dir string, hosts []string) (*Dev, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("at least one host is required")
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	dev := &Dev{
+		CACert: filepath.Join(dir, "codegenerator-ca.crt"),
+		Cert:   filepath.Join(dir, "codegenerator.crt"),
+		Key:    filepath.Join(dir, "codegenerator.key"),
+	}
+	caKeyFile := filepath.Join(dir, "codegenerator-ca.key")
+
+	ca, err := loadCA(dev.CACert, caKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading ca: %w", err)
+	}
+
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parsing ca: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := newTemplate(hosts[0], 90*24*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("signing certificate: %w", err)
+	}
+
+	if err := writeCertificate(dev.Cert, dev.Key, der, key); err != nil {
+		return nil, err
+	}
+
+	return dev, nil
+}
+
+// loadCA loads the CA from "certFile" and "keyFile", generating it if neither
+// exists. Only one of them existing is an error, rather than overwriting a
+// CA which may already be trusted.
+func loadCA(certFile, keyFile string) (tls.Certificate, error) {
+	certExists, err := exists(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	keyExists, err := exists(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	switch {
+	case !certExists && !keyExists:
+		return generateCA(certFile, keyFile)
+	case !keyExists:
+		return tls.Certificate{}, fmt.Errorf("%s exists without its key %s", certFile, keyFile)
+	case !certExists:
+		return tls.Certificate{}, fmt.Errorf("%s exists without its certificate %s", keyFile, certFile)
+	}
+
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func generateCA(certFile, keyFile string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template, err := newTemplate("codegenerator development CA", 10*365*24*time.Hour)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.MaxPathLenZero = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("creating ca: %w", err)
+	}
+
+	if err := writeCertificate(certFile, keyFile, der, key); err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.LoadX509KeyPair(certFile, keyFile)
+}
+
+func newTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"codegenerator development"}},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func writeCertificate(certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
@@ -0,0 +1,91 @@
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a certificate and key pair from disk, reloading them when
+// either file changes.
+//
+// Use GetCertificate as tls.Config.GetCertificate, new connections use the
+// latest certificate while existing connections are unaffected.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	mod     [2]time.Time
+	checked time.Time
+}
+
+// NewReloader loads the certificate and key pair, checking for changes at
+// most once every "interval".
+func NewReloader(certFile, keyFile string, interval time.Duration) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, interval: interval}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *Reloader) load() error {
+	mod, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.mod = mod
+
+	return nil
+}
+
+func (r *Reloader) modTimes() ([2]time.Time, error) {
+	var mod [2]time.Time
+	for i, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return mod, err
+		}
+
+		mod[i] = info.ModTime()
+	}
+
+	return mod, nil
+}
+
+// GetCertificate returns the current certificate, reloading it first if it
+// has changed on disk. If reloading fails the previous certificate is kept.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := time.Now(); now.Sub(r.checked) >= r.interval {
+		r.checked = now
+
+		// A failed reload, e.g. when only one of the pair has been updated
+		// so far, keeps the previous certificate and is retried later.
+		if mod, err := r.modTimes(); err == nil && mod != r.mod {
+			if err := r.load(); err != nil {
+				slog.Warn("reloading certificate", "cert", r.certFile, "key", r.keyFile, "error", err)
+			} else {
+				slog.Info("reloaded certificate", "cert", r.certFile, "key", r.keyFile)
+			}
+		}
+	}
+
+	return r.cert, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/CGA1123/codegenerator/auth"
+	"github.com/CGA1123/codegenerator/config"
+)
+
+// buildAuthenticator builds the authentication methods described by "cfg",
+// tried in the order static tokens, HMAC tokens and JWTs.
+func buildAuthenticator(cfg *config.Config) (auth.Chain, error) {
+	var chain auth.Chain
+
+	if len(cfg.Auth.Tokens) > 0 {
+		tokens := make(map[string]auth.Identity, len(cfg.Auth.Tokens))
+		for _, t := range cfg.Auth.Tokens {
+			tokens[t.Token] = auth.Identity{Subject: t.Subject, Groups: t.Groups}
+		}
+
+		chain = append(chain, auth.NewStaticTokens(tokens))
+	}
+
+	if cfg.Auth.HMAC != nil {
+		chain = append(chain, hmacTokens(cfg.Auth.HMAC))
+	}
+
+	if j := cfg.Auth.JWT; j != nil {
+		authn, err := auth.NewJWT(auth.JWTConfig{
+			JWKSFile:     j.JWKSFile,
+			Issuer:       j.Issuer,
+			Audience:     j.Audience,
+			SubjectClaim: j.SubjectClaim,
+			GroupsClaim:  j.GroupsClaim,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		chain = append(chain, authn)
+	}
+
+	return chain, nil
+}
+
+func hmacTokens(cfg *config.HMAC) *auth.HMACTokens {
+	secrets := make([][]byte, len(cfg.Secrets))
+	for i, secret := range cfg.Secrets {
+		secrets[i] = []byte(secret)
+	}
+
+	return auth.NewHMACTokens(secrets...)
+}
+
+// listenerAuthenticator adds client certificate authentication to "chain" for
+// listeners which verify client certificates.
+func listenerAuthenticator(chain auth.Chain, l config.Listener) auth.Authenticator {
+	if l.TLS == nil || l.TLS.ClientCA == "" {
+		return chain
+	}
+
+	return append(chain[:len(chain):len(chain)], &auth.ClientCertificates{Subject: l.TLS.ClientSubject})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+
+	"github.com/CGA1123/codegenerator/certs"
+)
+
+// devCertsCommand generates a local CA and a server certificate signed by it,
+// for use in development.
+func devCertsCommand(args []string) {
+	fs := flag.NewFlagSet("dev-certs", flag.ExitOnError)
+
+	var (
+		hosts = fs.String("host", "lvh.me,localhost,127.0.0.1", "Comma separated hostnames and IPs the certificate is valid for")
+		out   = fs.String("out", ".local/certstrap", "The directory the certificates are written to")
+	)
+	_ = fs.Parse(args)
+
+	dev, err := certs.GenerateDev(*out, strings.Split(*hosts, ","))
+	if err != nil {
+		log.Fatalf("generating certificates: %v", err)
+	}
+
+	fmt.Printf("Wrote %s and %s, signed by %s.\n\n", dev.Cert, dev.Key, dev.CACert)
+	fmt.Printf("Serve them with:\n\n  codegenerator -tls-crt %s -tls-key %s\n\n", dev.Cert, dev.Key)
+	fmt.Printf("To trust the CA:\n\n%s\n", trustInstructions(runtime.GOOS, dev.CACert))
+}
+
+func trustInstructions(goos, ca string) string {
+	switch goos {
+	case "darwin":
+		return fmt.Sprintf("  security add-trusted-cert -d -r trustRoot -p ssl -k ~/Library/Keychains/login.keychain %s", ca)
+	case "windows":
+		return fmt.Sprintf("  certutil -addstore -user Root %s", ca)
+	case "linux":
+		return fmt.Sprintf(`  # Debian, Ubuntu
+  sudo cp %[1]s /usr/local/share/ca-certificates/codegenerator-ca.crt && sudo update-ca-certificates
+
+  # Fedora, RHEL
+  sudo cp %[1]s /etc/pki/ca-trust/source/anchors/codegenerator-ca.crt && sudo update-ca-trust`, ca)
+	default:
+		return fmt.Sprintf("  Add %s to your system's trusted certificate authorities.", ca)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/CGA1123/codegenerator/config"
+)
+
+// flags are the server's command line flags.
+type flags struct {
+	set *flag.FlagSet
+
+	configPath   string
+	drainTimeout time.Duration
+
+	typ     string
+	address string
+	tlsCrt  string
+	tlsKey  string
+
+	pullUpstream string
+	pullAllow    string
+
+	ociCache  string
+	wasmCache string
+
+	maxConcurrency int
+	auditLog       string
+	otlpEndpoint   string
+
+	routes    routeFlags
+	listeners listenFlags
+}
+
+// newFlags defines the server's flags in "set".
+func newFlags(set *flag.FlagSet) *flags {
+	f := &flags{set: set}
+
+	set.StringVar(&f.configPath, "config", "", "The configuration file, flags which are set override its values")
+	set.DurationVar(&f.drainTimeout, "drain-timeout", 30*time.Second, "How long in-flight requests have to complete on shutdown")
+
+	set.StringVar(&f.typ, "type", "docker", "The types of the registry support docker, local, oci and inprocess, comma separated types are consulted in order")
+	set.StringVar(&f.address, "address", "0.0.0.0:443", "The address listened for by the service")
+	set.StringVar(&f.tlsCrt, "tls-crt", "", "The certificate used by TLS, if unset h2c is served")
+	set.StringVar(&f.tlsKey, "tls-key", "", "The certificate private key used by TLS")
+
+	set.StringVar(&f.pullUpstream, "pull-upstream", "", "The upstream registry missing docker plugin images are pulled from")
+	set.StringVar(&f.pullAllow, "pull-allow", "", "Comma separated <owner>/<plugin> patterns which may be pulled from the upstream registry")
+
+	set.StringVar(&f.ociCache, "oci-cache", "", "The directory oci plugin images are extracted into")
+	set.StringVar(&f.wasmCache, "wasm-cache", "", "The directory compiled wasm plugins are cached in")
+
+	set.IntVar(&f.maxConcurrency, "max-concurrency", 0, "The maximum number of plugin executions running at once, 0 for no limit")
+	set.StringVar(&f.auditLog, "audit-log", "", "Where GenerateCode calls are audited to, stdout, stderr or a file path")
+	set.StringVar(&f.otlpEndpoint, "otlp-endpoint", "", "The URL of the OTLP/HTTP collector traces are exported to, e.g. http://localhost:4318")
+
+	set.Var(&f.listeners, "listen", "Listen on <mode>=<address>, where mode is tls, h2c or unix (repeatable), tls listeners use -tls-crt and -tls-key")
+	set.Var(&f.routes, "route", "Route plugins to registry types, as <owner>[/<plugin>]=<type>[,<type>...] (repeatable)")
+
+	return f
+}
+
+// override applies the flags which are set to "cfg", they take precedence
+// over the file both at startup and when it is reloaded.
+func (f *flags) override(cfg *config.Config) {
+	f.set.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "type":
+			cfg.Registries = nil
+			for _, typ := range strings.Split(f.typ, ",") {
+				cfg.Registries = append(cfg.Registries, config.Registry{Type: typ, Path: registryPath(typ)})
+			}
+		case "address":
+			if len(f.listeners) == 0 {
+				cfg.Listeners = []config.Listener{{Mode: "h2c", Address: f.address}}
+				if f.tlsCrt != "" || f.tlsKey != "" {
+					cfg.Listeners[0].Mode = "tls"
+				}
+			}
+		case "tls-crt", "tls-key":
+			// Without a file, the default listener serves TLS rather
+			// than h2c, configured listeners are only given the
+			// certificate below.
+			if f.configPath == "" && len(f.listeners) == 0 {
+				cfg.Listeners[0].Mode = "tls"
+			}
+		case "listen":
+			cfg.Listeners = f.listeners
+		case "route":
+			cfg.Routes = f.routes
+		case "drain-timeout":
+			cfg.Shutdown.DrainTimeout = f.drainTimeout
+		case "otlp-endpoint":
+			cfg.Tracing.Endpoint = f.otlpEndpoint
+		case "audit-log":
+			cfg.Audit.Output = f.auditLog
+		case "max-concurrency":
+			cfg.Limits.Concurrency = f.maxConcurrency
+		}
+	})
+
+	// Registry specific flags apply to every registry of their type, so
+	// are applied once the registries are known.
+	for i := range cfg.Registries {
+		r := &cfg.Registries[i]
+
+		switch {
+		case r.Type == "docker" && f.pullUpstream != "":
+			r.Pull = &config.Pull{Upstream: f.pullUpstream, Allow: strings.Split(f.pullAllow, ",")}
+		case r.Type == "oci" && f.ociCache != "":
+			r.Cache = f.ociCache
+		case r.Type == "local" && f.wasmCache != "":
+			r.Cache = f.wasmCache
+		}
+	}
+
+	if f.tlsCrt != "" || f.tlsKey != "" {
+		for i := range cfg.Listeners {
+			if l := &cfg.Listeners[i]; l.Mode == "tls" && l.TLS == nil {
+				l.TLS = &config.TLS{Cert: f.tlsCrt, Key: f.tlsKey}
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/CGA1123/codegenerator/config"
+)
+
+func TestFlagsOverride(t *testing.T) {
+	t.Setenv("CODEGENERATOR_REGISTRY_PATH", "/srv/plugins")
+	t.Setenv("CODEGENERATOR_OCI_REGISTRY_PATH", "/srv/oci")
+
+	// file is the configuration file each test overrides.
+	file := func() *config.Config {
+		return &config.Config{
+			Listeners: []config.Listener{
+				{Mode: "tls", Address: "0.0.0.0:443"},
+				{Mode: "unix", Address: "/run/codegenerator.sock"},
+			},
+			Registries: []config.Registry{{Type: "local", Path: "/etc/plugins"}},
+			Shutdown:   config.Shutdown{DrainTimeout: time.Minute},
+			Limits:     config.Limits{Concurrency: 4},
+		}
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		// noFile starts from the default configuration, rather than file.
+		noFile bool
+		want   func(c *config.Config)
+	}{
+		{
+			name: "no flags",
+			want: func(*config.Config) {},
+		},
+		{
+			name: "defaults of unset flags are not applied",
+			args: []string{"-audit-log", "stdout"},
+			want: func(c *config.Config) { c.Audit.Output = "stdout" },
+		},
+		{
+			name: "set flags",
+			args: []string{"-drain-timeout", "5s", "-max-concurrency", "8", "-otlp-endpoint", "http://localhost:4318"},
+			want: func(c *config.Config) {
+				c.Shutdown.DrainTimeout = 5 * time.Second
+				c.Limits.Concurrency = 8
+				c.Tracing.Endpoint = "http://localhost:4318"
+			},
+		},
+		{
+			name: "flags set to their default",
+			args: []string{"-drain-timeout", "30s", "-max-concurrency", "0"},
+			want: func(c *config.Config) {
+				c.Shutdown.DrainTimeout = 30 * time.Second
+				c.Limits.Concurrency = 0
+			},
+		},
+		{
+			name: "type replaces the registries",
+			args: []string{"-type", "oci,docker", "-oci-cache", "/var/cache/oci"},
+			want: func(c *config.Config) {
+				c.Registries = []config.Registry{
+					{Type: "oci", Path: "/srv/oci", Cache: "/var/cache/oci"},
+					{Type: "docker", Path: "/srv/plugins"},
+				}
+			},
+		},
+		{
+			name: "registry flags apply to the file's registries",
+			args: []string{"-wasm-cache", "/var/cache/wasm"},
+			want: func(c *config.Config) { c.Registries[0].Cache = "/var/cache/wasm" },
+		},
+		{
+			name: "pull-through",
+			args: []string{"-type", "docker", "-pull-upstream", "ghcr.io/acme", "-pull-allow", "acme/*,bufbuild/*"},
+			want: func(c *config.Config) {
+				c.Registries = []config.Registry{{
+					Type: "docker",
+					Path: "/srv/plugins",
+					Pull: &config.Pull{Upstream: "ghcr.io/acme", Allow: []string{"acme/*", "bufbuild/*"}},
+				}}
+			},
+		},
+		{
+			name: "routes",
+			args: []string{"-route", "acme/protoc-gen-doc=docker", "-route", "experimental=local"},
+			want: func(c *config.Config) {
+				c.Routes = []config.Route{
+					{Owner: "acme", Plugin: "protoc-gen-doc", Registries: []string{"docker"}},
+					{Owner: "experimental", Registries: []string{"local"}},
+				}
+			},
+		},
+		{
+			name: "address replaces the listeners",
+			args: []string{"-address", "127.0.0.1:8080"},
+			want: func(c *config.Config) {
+				c.Listeners = []config.Listener{{Mode: "h2c", Address: "127.0.0.1:8080"}}
+			},
+		},
+		{
+			name: "listen takes precedence over address",
+			args: []string{"-address", "127.0.0.1:8080", "-listen", "h2c=127.0.0.1:9090", "-listen", "unix=/tmp/cg.sock"},
+			want: func(c *config.Config) {
+				c.Listeners = []config.Listener{
+					{Mode: "h2c", Address: "127.0.0.1:9090"},
+					{Mode: "unix", Address: "/tmp/cg.sock"},
+				}
+			},
+		},
+		{
+			name: "certificate given to the file's tls listeners",
+			args: []string{"-tls-crt", "tls.crt", "-tls-key", "tls.key"},
+			want: func(c *config.Config) {
+				c.Listeners[0].TLS = &config.TLS{Cert: "tls.crt", Key: "tls.key"}
+			},
+		},
+		{
+			name:   "certificate makes the default listener tls",
+			args:   []string{"-tls-crt", "tls.crt", "-tls-key", "tls.key"},
+			noFile: true,
+			want: func(c *config.Config) {
+				c.Listeners = []config.Listener{{Mode: "tls", Address: "0.0.0.0:443", TLS: &config.TLS{Cert: "tls.crt", Key: "tls.key"}}}
+			},
+		},
+		{
+			name: "address with a certificate",
+			args: []string{"-address", "127.0.0.1:8443", "-tls-crt", "tls.crt", "-tls-key", "tls.key"},
+			want: func(c *config.Config) {
+				c.Listeners = []config.Listener{{Mode: "tls", Address: "127.0.0.1:8443", TLS: &config.TLS{Cert: "tls.crt", Key: "tls.key"}}}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := flag.NewFlagSet("codegenerator", flag.ContinueOnError)
+			set.SetOutput(io.Discard)
+
+			f := newFlags(set)
+
+			args := tt.args
+			if !tt.noFile {
+				args = append([]string{"-config", "codegenerator.yaml"}, args...)
+			}
+
+			if err := set.Parse(args); err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			base := file
+			if tt.noFile {
+				base = config.Default
+			}
+
+			got, want := base(), base()
+			f.override(got)
+			tt.want(want)
+
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("override() = %+v\nwant %+v", got, want)
+			}
+
+			// Overriding a reloaded file gives the same result.
+			reloaded := base()
+			f.override(reloaded)
+			if !reflect.DeepEqual(reloaded, got) {
+				t.Fatalf("override() of a reloaded file = %+v, want %+v", reloaded, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/CGA1123/codegenerator/certs"
+	"github.com/CGA1123/codegenerator/config"
+)
+
+// server is an http.Server bound to a configured listener.
+type server struct {
+	config   config.Listener
+	srv      *http.Server
+	listener net.Listener
+}
+
+// listen binds the configured listener, serving "handler" on it.
+func listen(l config.Listener, handler http.Handler) (*server, error) {
+	s := &server{config: l, srv: &http.Server{}}
+
+	var err error
+	switch l.Mode {
+	case "tls":
+		s.srv.Handler = handler
+		s.srv.TLSConfig, err = buildTLSConfig(l.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("building tls config: %w", err)
+		}
+
+		s.listener, err = net.Listen("tcp", l.Address)
+	case "h2c":
+		// Use h2c so we can serve HTTP/2 without TLS.
+		s.srv.Handler = h2c.NewHandler(handler, &http2.Server{})
+		s.listener, err = net.Listen("tcp", l.Address)
+	case "unix":
+		s.srv.Handler = h2c.NewHandler(handler, &http2.Server{})
+
+		// Remove a socket left behind by a previous process, but nothing
+		// else which happens to be at the path.
+		if info, err := os.Lstat(l.Address); err == nil {
+			if info.Mode().Type() != fs.ModeSocket {
+				return nil, fmt.Errorf("listening on %s: file exists and is not a socket", l.Address)
+			}
+
+			if err := os.Remove(l.Address); err != nil {
+				return nil, fmt.Errorf("removing stale socket: %w", err)
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("checking for stale socket: %w", err)
+		}
+
+		s.listener, err = net.Listen("unix", l.Address)
+	default:
+		return nil, fmt.Errorf("unknown listener mode %q", l.Mode)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", l.Address, err)
+	}
+
+	return s, nil
+}
+
+// serve serves requests until the server is shut down.
+func (s *server) serve() error {
+	var err error
+	if s.config.Mode == "tls" {
+		err = s.srv.ServeTLS(s.listener, "", "")
+	} else {
+		err = s.srv.Serve(s.listener)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// listenFlags collects `-listen <mode>=<address>` flags.
+type listenFlags []config.Listener
+
+func (l *listenFlags) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *listenFlags) Set(value string) error {
+	mode, address, ok := strings.Cut(value, "=")
+	if !ok || mode == "" || address == "" {
+		return fmt.Errorf("expected <mode>=<address>, got %q", value)
+	}
+
+	*l = append(*l, config.Listener{Mode: mode, Address: address})
+
+	return nil
+}
+
+// buildTLSConfig builds the server TLS configuration, verifying client
+// certificates against the configured CA bundle if set.
+//
+// The server certificate is reloaded from disk when it changes.
+func buildTLSConfig(cfg *config.TLS) (*tls.Config, error) {
+	reloader, err := certs.NewReloader(cfg.Cert, cfg.Key, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if cfg.ClientCA == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(cfg.ClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("reading client ca: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCA)
+	}
+
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	if cfg.ClientAuth == "optional" {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
@@ -1,73 +1,306 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"io"
 	"log"
-	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"connectrpc.com/connect"
+	"connectrpc.com/grpchealth"
 
 	"github.com/CGA1123/codegenerator"
+	"github.com/CGA1123/codegenerator/audit"
+	"github.com/CGA1123/codegenerator/auth"
+	"github.com/CGA1123/codegenerator/config"
 	"github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1/registryv1alpha1connect"
+	"github.com/CGA1123/codegenerator/health"
+	"github.com/CGA1123/codegenerator/limit"
+	"github.com/CGA1123/codegenerator/metrics"
+	"github.com/CGA1123/codegenerator/plugin"
 	"github.com/CGA1123/codegenerator/registry"
-	"github.com/CGA1123/codegenerator/registry/docker"
-	"github.com/CGA1123/codegenerator/registry/local"
-	"golang.org/x/net/http2"
-	"golang.org/x/net/http2/h2c"
 )
 
 func main() {
-	var (
-		typ     = flag.String("type", "docker", "The types of the registry support docker and local")
-		address = flag.String("address", "0.0.0.0:443", "The address listened for by the service")
-		tlsCrt  = flag.String("tls-crt", ".local/certstrap/codegenerator.crt", "The certificate used by TLS")
-		tlsKey  = flag.String("tls-key", ".local/certstrap/codegenerator.key", "The certificate private key used by TLS")
-	)
-	flag.Parse()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "token":
+			tokenCommand(os.Args[2:])
+			return
+		case "dev-certs":
+			devCertsCommand(os.Args[2:])
+			return
+		}
+	}
 
-	path, _ := os.LookupEnv("CODEGENERATOR_REGISTRY_PATH")
+	f := newFlags(flag.CommandLine)
+	flag.Parse()
 
-	var registry registry.Registry
-	switch *typ {
-	case "local":
-		if path == "" {
-			log.Fatalf("CODEGENERATOR_REGISTRY_PATH is not set")
+	cfg := config.Default()
+	if f.configPath != "" {
+		var err error
+		cfg, err = config.Load(f.configPath)
+		if err != nil {
+			log.Fatalf("loading config: %v", err)
 		}
-		registry = local.LocalRegistry(path)
-	default:
-		registry = docker.DockerRegistry(path)
 	}
-	service := &codegenerator.Service{Registry: registry}
+	f.override(cfg)
 
-	mux := http.NewServeMux()
-	path, handler := registryv1alpha1connect.NewCodeGenerationServiceHandler(service)
-	mux.Handle(path, handler)
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("invalid config:\n%v", err)
+	}
 
-	log.Println("server listen address:", *address)
-	ln, err := net.Listen("tcp", *address)
+	flushTraces, err := setupTracing(cfg.Tracing)
 	if err != nil {
-		log.Fatalf("listen address err: %v", err)
+		log.Fatalf("setting up tracing: %v", err)
 	}
 
-	defer ln.Close()
+	service := &codegenerator.Service{
+		Plugins:              cfg.PluginConfig,
+		ApplyInsertionPoints: cfg.ApplyInsertionPoints,
+		RequestLimits: codegenerator.RequestLimits{
+			MaxRequestBytes: cfg.Limits.MaxRequestBytes,
+			MaxFiles:        cfg.Limits.MaxFiles,
+			MaxPlugins:      cfg.Limits.MaxPlugins,
+			MaxOutputBytes:  cfg.Limits.MaxOutputBytes,
+		},
+	}
 
-	if *tlsCrt != "" && *tlsKey != "" {
-		err = http.ServeTLS(
-			ln,
-			// Use h2c so we can serve HTTP/2 without TLS.
-			h2c.NewHandler(mux, &http2.Server{}),
-			*tlsCrt,
-			*tlsKey,
-		)
-	} else {
-		err = http.Serve(
-			ln,
-			// Use h2c so we can serve HTTP/2 without TLS.
-			h2c.NewHandler(mux, &http2.Server{}),
-		)
+	if cfg.CompilerVersion != "" {
+		service.CompilerVersion, err = plugin.ParseCompilerVersion(cfg.CompilerVersion)
+		if err != nil {
+			log.Fatalf("parsing compiler version: %v", err)
+		}
 	}
 
-	if err != nil {
-		log.Fatalf("server running err: %v", err)
+	if cfg.LimitsEnabled() {
+		service.Limiter = limit.New(limitConfig(cfg.Limits))
+	}
+
+	if cfg.Audit.Output != "" {
+		w, err := auditOutput(cfg.Audit)
+		if err != nil {
+			log.Fatalf("opening audit log: %v", err)
+		}
+		defer w.Close()
+
+		service.Auditor = audit.NewLogger(w)
+	}
+
+	if len(cfg.Policies) > 0 {
+		policy := auth.NewPolicy(cfg.Rules())
+		service.Authorizer = policy
+
+		if f.configPath != "" {
+			go watchConfig(f.configPath, 10*time.Second, f.override, func(cfg *config.Config) error {
+				if len(cfg.Policies) == 0 {
+					return errors.New("policies cannot be removed without a restart")
+				}
+
+				policy.Store(cfg.Rules())
+
+				return nil
+			})
+		}
+	}
+
+	// The registry is loaded once the listeners are up, so health checks are
+	// answered while e.g. wasm plugins are compiled.
+	loaded := make(chan struct{})
+
+	checker := health.NewChecker(registryv1alpha1connect.CodeGenerationServiceName)
+	checker.Add("registry", func(ctx context.Context) error {
+		select {
+		case <-loaded:
+		default:
+			return errors.New("loading")
+		}
+
+		if c, ok := service.Registry.(registry.Checker); ok {
+			return c.Check(ctx)
+		}
+
+		return nil
+	})
+	checker.Add("server", func(context.Context) error {
+		if service.Draining() {
+			return errors.New("shutting down")
+		}
+
+		return nil
+	})
+
+	var handlerOpts []connect.HandlerOption
+	if cfg.Limits.MaxRequestBytes > 0 {
+		handlerOpts = append(handlerOpts, connect.WithReadMaxBytes(cfg.Limits.MaxRequestBytes))
+	}
+
+	path, handler := registryv1alpha1connect.NewCodeGenerationServiceHandler(service, handlerOpts...)
+	handler = unavailableUntil(loaded, handler)
+	healthPath, healthHandler := grpchealth.NewHandler(checker)
+
+	var authn auth.Chain
+	if cfg.AuthEnabled() {
+		var err error
+		authn, err = buildAuthenticator(cfg)
+		if err != nil {
+			log.Fatalf("building authenticator: %v", err)
+		}
+	}
+
+	servers := make([]*server, len(cfg.Listeners))
+	for i, l := range cfg.Listeners {
+		h := handler
+		if cfg.AuthEnabled() {
+			h = auth.Middleware(listenerAuthenticator(authn, l), cfg.Auth.Anonymous, h)
+		}
+
+		mux := http.NewServeMux()
+		if l.Serves("api") {
+			mux.Handle(path, h)
+		}
+		if l.Serves("health") {
+			mux.Handle(healthPath, healthHandler)
+			mux.Handle("/healthz", health.Live())
+			mux.Handle("/readyz", checker)
+		}
+		if l.Serves("metrics") {
+			m := metrics.Handler()
+			if cfg.AuthEnabled() {
+				m = auth.Middleware(listenerAuthenticator(authn, l), cfg.Auth.Anonymous, m)
+			}
+
+			mux.Handle("/metrics", m)
+		}
+
+		s, err := listen(l, mux)
+		if err != nil {
+			log.Fatalf("listen address err: %v", err)
+		}
+
+		log.Println("server listen address:", l.Mode, l.Address)
+		servers[i] = s
+	}
+
+	go func() {
+		service.Registry = buildRegistry(cfg)
+		close(loaded)
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, len(servers))
+	for _, s := range servers {
+		go func() {
+			errs <- s.serve()
+		}()
+	}
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			log.Fatalf("server running err: %v", err)
+		}
+	case <-ctx.Done():
+		stop()
+	}
+
+	log.Println("shutting down, draining in-flight requests for", cfg.Shutdown.DrainTimeout)
+	shutdown(cfg.Shutdown.DrainTimeout, service, servers)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := flushTraces(ctx); err != nil {
+		log.Println("flushing traces:", err)
+	}
+
+	log.Println("shut down")
+}
+
+// shutdown stops accepting connections and waits up to "timeout" for
+// in-flight requests to complete, then cancels any remaining plugin
+// executions and waits for them to exit.
+func shutdown(timeout time.Duration, service *codegenerator.Service, servers []*server) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(len(servers))
+	for _, s := range servers {
+		go func() {
+			defer wg.Done()
+
+			if err := s.srv.Shutdown(ctx); err != nil {
+				s.srv.Close()
+			}
+		}()
+	}
+
+	// The service tracks requests itself, as http.Server does not track
+	// connections hijacked by h2c.
+	if err := service.Shutdown(ctx); err != nil {
+		log.Println("cancelled in-flight requests:", err)
 	}
+
+	wg.Wait()
+}
+
+// unavailableUntil rejects requests with a Connect `unavailable` error until
+// "ready" is closed.
+func unavailableUntil(ready <-chan struct{}, next http.Handler) http.Handler {
+	errw := connect.NewErrorWriter()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-ready:
+			next.ServeHTTP(w, r)
+		default:
+			_ = errw.Write(w, r, connect.NewError(connect.CodeUnavailable, errors.New("server is starting")))
+		}
+	})
+}
+
+// auditOutput opens where the audit log is written to.
+func auditOutput(cfg config.Audit) (io.WriteCloser, error) {
+	switch cfg.Output {
+	case "stdout":
+		return nopCloser{os.Stdout}, nil
+	case "stderr":
+		return nopCloser{os.Stderr}, nil
+	default:
+		return audit.OpenRotatingFile(cfg.Output, int64(cfg.MaxSizeMB)<<20, cfg.MaxBackups)
+	}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func limitConfig(cfg config.Limits) limit.Config {
+	c := limit.Config{
+		Concurrency:  cfg.Concurrency,
+		Queue:        cfg.Queue,
+		QueueTimeout: cfg.QueueTimeout,
+	}
+
+	if cfg.Callers != nil {
+		c.Callers = limit.Rate{Rate: cfg.Callers.PerSecond, Burst: cfg.Callers.Burst}
+	}
+
+	if cfg.Plugins != nil {
+		c.Plugins = limit.Rate{Rate: cfg.Plugins.PerSecond, Burst: cfg.Plugins.Burst}
+	}
+
+	return c
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/CGA1123/codegenerator/config"
+	"github.com/CGA1123/codegenerator/registry"
+	"github.com/CGA1123/codegenerator/registry/composite"
+	"github.com/CGA1123/codegenerator/registry/docker"
+	"github.com/CGA1123/codegenerator/registry/inprocess"
+	"github.com/CGA1123/codegenerator/registry/local"
+	"github.com/CGA1123/codegenerator/registry/oci"
+)
+
+// buildRegistry builds the registries described by "cfg", chaining them
+// together if there is more than one.
+func buildRegistry(cfg *config.Config) registry.Registry {
+	if len(cfg.Registries) == 1 && len(cfg.Routes) == 0 {
+		return buildBackend(cfg, cfg.Registries[0])
+	}
+
+	backends := make([]composite.Backend, len(cfg.Registries))
+	for i, r := range cfg.Registries {
+		backends[i] = composite.Backend{Name: r.RegistryName(), Registry: buildBackend(cfg, r)}
+	}
+
+	rules := make([]composite.Rule, len(cfg.Routes))
+	for i, r := range cfg.Routes {
+		rules[i] = composite.Rule{Owner: r.Owner, Plugin: r.Plugin, Backends: r.Registries}
+	}
+
+	return composite.CompositeRegistry(backends, rules)
+}
+
+func buildBackend(cfg *config.Config, r config.Registry) registry.Registry {
+	cache := cfg.CacheDir(r)
+
+	switch r.Type {
+	case "local":
+		var opts []local.Option
+		if cache != "" {
+			opts = append(opts, local.WithWasmCache(cache))
+		}
+		return local.LocalRegistry(r.Path, opts...)
+	case "oci":
+		return oci.OCIRegistry(r.Path, cache)
+	case "inprocess":
+		return inprocess.Default
+	default:
+		var opts []docker.Option
+		if r.Pull != nil {
+			opts = append(opts, docker.WithPullThrough(r.Pull.Upstream, r.Pull.Allow))
+		}
+		return docker.DockerRegistry(r.Path, opts...)
+	}
+}
+
+// registryPath returns the path for a registry type, read from
+// `CODEGENERATOR_<TYPE>_REGISTRY_PATH` and falling back to
+// `CODEGENERATOR_REGISTRY_PATH`.
+func registryPath(typ string) string {
+	if path, ok := os.LookupEnv("CODEGENERATOR_" + strings.ToUpper(typ) + "_REGISTRY_PATH"); ok {
+		return path
+	}
+
+	path, _ := os.LookupEnv("CODEGENERATOR_REGISTRY_PATH")
+
+	return path
+}
+
+// routeFlags collects `-route <owner>[/<plugin>]=<type>[,<type>...]` flags.
+type routeFlags []config.Route
+
+func (r *routeFlags) String() string {
+	return fmt.Sprint(*r)
+}
+
+func (r *routeFlags) Set(value string) error {
+	pattern, types, ok := strings.Cut(value, "=")
+	if !ok || pattern == "" || types == "" {
+		return fmt.Errorf("expected <owner>[/<plugin>]=<type>[,<type>...], got %q", value)
+	}
+
+	owner, plugin, _ := strings.Cut(pattern, "/")
+
+	*r = append(*r, config.Route{
+		Owner:      owner,
+		Plugin:     plugin,
+		Registries: strings.Split(types, ","),
+	})
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/CGA1123/codegenerator/config"
+)
+
+// watchConfig calls "reload" with the configuration file at "path" whenever
+// the process receives SIGHUP, or the file is modified. "override" is applied
+// to the file before it is validated, as the flags are at startup.
+//
+// Invalid configuration is logged and ignored, keeping the current
+// configuration in place.
+func watchConfig(path string, interval time.Duration, override func(*config.Config), reload func(*config.Config) error) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	modified := modTime(path)
+
+	for {
+		select {
+		case <-hup:
+		case <-ticker.C:
+			m := modTime(path)
+			if m.Equal(modified) {
+				continue
+			}
+
+			modified = m
+		}
+
+		cfg, err := config.Load(path)
+		if err == nil {
+			override(cfg)
+			err = cfg.Validate()
+		}
+
+		if err == nil {
+			err = reload(cfg)
+		}
+
+		if err != nil {
+			slog.Error("reloading config", "path", path, "error", err)
+			continue
+		}
+
+		slog.Info("reloaded config", "path", path)
+	}
+}
+
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
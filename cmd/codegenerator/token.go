@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/CGA1123/codegenerator/auth"
+	"github.com/CGA1123/codegenerator/config"
+)
+
+// tokenCommand issues a HMAC token using the secret from the configuration
+// file.
+func tokenCommand(args []string) {
+	fs := flag.NewFlagSet("token", flag.ExitOnError)
+
+	var (
+		configPath = fs.String("config", "", "The configuration file containing the HMAC secret")
+		subject    = fs.String("subject", "", "The subject of the token")
+		groups     = fs.String("groups", "", "Comma separated groups of the token")
+		ttl        = fs.Duration("ttl", 24*time.Hour, "How long the token is valid for")
+	)
+	_ = fs.Parse(args)
+
+	if *configPath == "" || *subject == "" {
+		log.Fatalf("-config and -subject must be set")
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("loading config: %v", err)
+	}
+
+	if cfg.Auth.HMAC == nil || len(cfg.Auth.HMAC.Secrets) == 0 {
+		log.Fatalf("config has no auth.hmac.secrets")
+	}
+
+	id := auth.Identity{Subject: *subject}
+	if *groups != "" {
+		id.Groups = strings.Split(*groups, ",")
+	}
+
+	token, err := hmacTokens(cfg.Auth.HMAC).Sign(id, *ttl)
+	if err != nil {
+		log.Fatalf("signing token: %v", err)
+	}
+
+	fmt.Println(token)
+}
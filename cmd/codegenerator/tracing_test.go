@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"connectrpc.com/connect"
+	collectortracev1 "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	tracev1 "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	"github.com/CGA1123/codegenerator"
+	"github.com/CGA1123/codegenerator/config"
+	imagev1 "github.com/CGA1123/codegenerator/gen/buf/alpha/image/v1"
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+	"github.com/CGA1123/codegenerator/registry/inprocess"
+)
+
+// collector is an OTLP/HTTP collector, recording the spans exported to it.
+type collector struct {
+	mu       sync.Mutex
+	resource map[string]any
+	spans    []*tracev1.Span
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		http.Error(w, "unexpected export", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := &collectortracev1.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	for _, rs := range req.GetResourceSpans() {
+		c.resource = attributes(rs.GetResource().GetAttributes())
+		for _, ss := range rs.GetScopeSpans() {
+			c.spans = append(c.spans, ss.GetSpans()...)
+		}
+	}
+	c.mu.Unlock()
+
+	res, err := proto.Marshal(&collectortracev1.ExportTraceServiceResponse{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(res)
+}
+
+func attributes(kvs []*commonv1.KeyValue) map[string]any {
+	attrs := make(map[string]any, len(kvs))
+	for _, kv := range kvs {
+		switch v := kv.GetValue().GetValue().(type) {
+		case *commonv1.AnyValue_StringValue:
+			attrs[kv.GetKey()] = v.StringValue
+		case *commonv1.AnyValue_IntValue:
+			attrs[kv.GetKey()] = v.IntValue
+		default:
+			attrs[kv.GetKey()] = kv.GetValue().String()
+		}
+	}
+
+	return attrs
+}
+
+func TestTracing(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	// The collector's address is configured as documented, without the
+	// path spans are exported to.
+	shutdown, err := setupTracing(config.Tracing{Endpoint: srv.URL, SampleRatio: 1})
+	if err != nil {
+		t.Fatalf("setupTracing() error = %v", err)
+	}
+
+	reg := inprocess.InProcessRegistry()
+	reg.Register("acme", "protoc-gen-test", "v1.0.0", func(_ context.Context, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+		return &pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{
+			{Name: proto.String("a.txt"), Content: proto.String(req.GetFileToGenerate()[0])},
+		}}, nil
+	})
+
+	svc := &codegenerator.Service{Registry: reg}
+
+	req := connect.NewRequest(&v1alpha1.GenerateCodeRequest{
+		Image: &imagev1.Image{File: []*imagev1.ImageFile{
+			{Name: proto.String("a.proto"), Syntax: proto.String("proto3")},
+		}},
+		Requests: []*v1alpha1.PluginGenerationRequest{{
+			PluginReference: &v1alpha1.CuratedPluginReference{Owner: "acme", Name: "protoc-gen-test", Version: "v1.0.0"},
+		}},
+	})
+
+	// The caller's trace is continued.
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req.Header().Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+	if _, err := svc.GenerateCode(context.Background(), req); err != nil {
+		t.Fatalf("GenerateCode() error = %v", err)
+	}
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if got := c.resource["service.name"]; got != "codegenerator" {
+		t.Errorf("service.name = %v, want codegenerator", got)
+	}
+
+	spans := map[string]*tracev1.Span{}
+	for _, span := range c.spans {
+		spans[span.GetName()] = span
+	}
+
+	root := spans["buf.alpha.registry.v1alpha1.CodeGenerationService/GenerateCode"]
+	if root == nil {
+		t.Fatalf("GenerateCode span was not exported, got %d spans", len(c.spans))
+	}
+
+	if root.GetKind() != tracev1.Span_SPAN_KIND_SERVER {
+		t.Errorf("GenerateCode span kind = %v, want server", root.GetKind())
+	}
+
+	if got := hex.EncodeToString(root.GetTraceId()); got != traceID {
+		t.Errorf("GenerateCode trace = %v, want %v", got, traceID)
+	}
+
+	assertAttributes(t, root, map[string]any{
+		"rpc.system":                    "connect_rpc",
+		"rpc.service":                   "buf.alpha.registry.v1alpha1.CodeGenerationService",
+		"rpc.method":                    "GenerateCode",
+		"rpc.connect_rpc.error_code":    "ok",
+		"codegenerator.request.plugins": int64(1),
+		"codegenerator.request.files":   int64(1),
+		"codegenerator.request.size":    int64(proto.Size(req.Msg)),
+		"codegenerator.response.size":   nil,
+		"codegenerator.plugin.name":     absent,
+	})
+
+	plugin := map[string]any{
+		"codegenerator.plugin.owner":   "acme",
+		"codegenerator.plugin.name":    "protoc-gen-test",
+		"codegenerator.plugin.version": "v1.0.0",
+	}
+
+	for name, want := range map[string]map[string]any{
+		"registry.Get": {},
+		"ImageToCodeGeneratorRequest": {
+			"codegenerator.plugin.files_to_generate": int64(1),
+			"codegenerator.plugin.proto_files":       int64(1),
+		},
+		"plugin.Generate": {
+			"codegenerator.plugin.request.size":   nil,
+			"codegenerator.plugin.response.files": int64(1),
+			"codegenerator.plugin.response.size":  nil,
+		},
+	} {
+		span := spans[name]
+		if span == nil {
+			t.Errorf("%s span was not exported", name)
+			continue
+		}
+
+		if string(span.GetParentSpanId()) != string(root.GetSpanId()) {
+			t.Errorf("%s span is not a child of the GenerateCode span", name)
+		}
+
+		for k, v := range plugin {
+			want[k] = v
+		}
+
+		assertAttributes(t, span, want)
+	}
+}
+
+// absent is expected of attributes which must not be set.
+var absent = struct{}{}
+
+// assertAttributes checks the attributes of "span" against "want", a nil
+// value only requiring the attribute to be set.
+func assertAttributes(t *testing.T, span *tracev1.Span, want map[string]any) {
+	t.Helper()
+
+	got := attributes(span.GetAttributes())
+	for k, v := range want {
+		value, ok := got[k]
+		switch {
+		case v == absent && ok:
+			t.Errorf("%s: attribute %s = %v, want unset", span.GetName(), k, value)
+		case v == absent:
+		case !ok:
+			t.Errorf("%s: attribute %s is not set", span.GetName(), k)
+		case v != nil && value != v:
+			t.Errorf("%s: attribute %s = %v, want %v", span.GetName(), k, value, v)
+		}
+	}
+}
+
+func TestTracesEndpoint(t *testing.T) {
+	tests := map[string]string{
+		"http://otel-collector:4318":               "http://otel-collector:4318/v1/traces",
+		"http://otel-collector:4318/":              "http://otel-collector:4318/v1/traces",
+		"https://otel-collector:4318/v1/traces":    "https://otel-collector:4318/v1/traces",
+		"https://collector.example/otlp/v1/traces": "https://collector.example/otlp/v1/traces",
+	}
+
+	for endpoint, want := range tests {
+		got, err := tracesEndpoint(endpoint)
+		if err != nil {
+			t.Fatalf("tracesEndpoint(%q) error = %v", endpoint, err)
+		}
+
+		if got != want {
+			t.Errorf("tracesEndpoint(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
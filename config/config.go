@@ -0,0 +1,862 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/CGA1123/codegenerator"
+	"github.com/CGA1123/codegenerator/auth"
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+	"github.com/CGA1123/codegenerator/managed"
+	"github.com/CGA1123/codegenerator/plugin"
+)
+
+// Config describes a codegenerator server.
+//
+// It is read from a YAML (or JSON) file, e.g.
+//
+//	listeners:
+//	  - mode: tls
+//	    address: 0.0.0.0:443
+//	    tls:
+//	      cert: /etc/codegenerator/tls.crt
+//	      key: /etc/codegenerator/tls.key
+//	  - mode: unix
+//	    address: /run/codegenerator.sock
+//	registries:
+//	  - type: local
+//	    path: ${PLUGINS_DIR}
+//	  - type: docker
+//	    pull:
+//	      upstream: ghcr.io/acme
+//	      allow: ["acme/*"]
+//	routes:
+//	  - owner: experimental
+//	    registries: [local]
+//	plugins:
+//	  - match: acme/protoc-gen-doc
+//	    timeout: 30s
+//	cache:
+//	  dir: /var/cache/codegenerator
+//	auth:
+//	  tokens:
+//	    - token: ${CI_TOKEN}
+//	      subject: ci
+//	      groups: [ci]
+//	policies:
+//	  - groups: [ci]
+//	    owners: [acme]
+//	tracing:
+//	  endpoint: http://otel-collector:4318
+//	audit:
+//	  output: /var/log/codegenerator/audit.log
+//	limits:
+//	  callers:
+//	    per_second: 2
+//	    burst: 10
+//	  concurrency: 16
+//	  queue: 64
+type Config struct {
+	Listeners  []Listener `yaml:"listeners"`
+	Registries []Registry `yaml:"registries"`
+	Routes     []Route    `yaml:"routes"`
+	Plugins    []Plugin   `yaml:"plugins"`
+	Cache      Cache      `yaml:"cache"`
+	Auth       Auth       `yaml:"auth"`
+	Policies   []Policy   `yaml:"policies"`
+	Shutdown   Shutdown   `yaml:"shutdown"`
+	Tracing    Tracing    `yaml:"tracing"`
+	Audit      Audit      `yaml:"audit"`
+	Limits     Limits     `yaml:"limits"`
+
+	// CompilerVersion is given to plugins as the version of the compiler,
+	// defaulting to the version of the buf CLI making the request.
+	CompilerVersion string `yaml:"compiler_version"`
+
+	// ApplyInsertionPoints applies the insertion points of plugins to the
+	// files generated by earlier plugins of a request on the server.
+	ApplyInsertionPoints bool `yaml:"apply_insertion_points"`
+
+	// pluginConfigs are built from Plugins by Validate, so they are not
+	// rebuilt for every request.
+	pluginConfigs []codegenerator.PluginConfig
+}
+
+// Listener configures where the server accepts connections.
+type Listener struct {
+	// Mode is one of:
+	//
+	//   - tls: HTTPS (HTTP/1.1 and HTTP/2) on a TCP address.
+	//   - h2c: plaintext HTTP/1.1 and HTTP/2 on a TCP address.
+	//   - unix: plaintext HTTP/1.1 and HTTP/2 on a unix socket.
+	Mode string `yaml:"mode"`
+	// Address is the `<host>:<port>` to listen on, or the path of the socket
+	// for unix listeners.
+	Address string `yaml:"address"`
+	// TLS is required for tls listeners.
+	TLS *TLS `yaml:"tls"`
+	// Serve are the endpoints served by the listener, any of "api", the code
+	// generation service, "health", the health checks, and "metrics",
+	// defaulting to all of them.
+	Serve []string `yaml:"serve"`
+}
+
+// Serves reports whether the listener serves "endpoint".
+func (l Listener) Serves(endpoint string) bool {
+	return len(l.Serve) == 0 || slices.Contains(l.Serve, endpoint)
+}
+
+// TLS configures the server certificate of a tls listener.
+type TLS struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+
+	// ClientCA is a PEM bundle of the CAs client certificates must be signed
+	// by, enabling mutual TLS. Callers presenting a certificate are
+	// authenticated by it.
+	ClientCA string `yaml:"client_ca"`
+	// ClientAuth is "require" (the default) to reject connections without a
+	// client certificate, or "optional" to allow callers to authenticate
+	// with other methods instead.
+	ClientAuth string `yaml:"client_auth"`
+	// ClientSubject selects the part of the client certificate used as the
+	// caller's subject, one of "uri", "dns", "email" or "cn". Defaults to
+	// the first available, in that order.
+	ClientSubject string `yaml:"client_subject"`
+}
+
+// Registry configures a source of plugins.
+type Registry struct {
+	// Name is used to refer to the registry from routes, defaulting to the
+	// type.
+	Name string `yaml:"name"`
+	// Type is one of docker, local, oci or inprocess.
+	Type string `yaml:"type"`
+	// Path is the directory of a local or oci registry, or the image prefix
+	// of a docker registry.
+	Path string `yaml:"path"`
+	// Cache is where oci images are extracted to, or compiled wasm plugins
+	// are cached, defaulting to a directory in Config.Cache.
+	Cache string `yaml:"cache"`
+	// Pull enables on-demand image pulls for a docker registry. Without it,
+	// a docker registry provides every plugin, and missing images are pulled
+	// by `docker run`.
+	Pull *Pull `yaml:"pull"`
+}
+
+// Pull configures pulling missing docker images from an upstream registry.
+type Pull struct {
+	Upstream string   `yaml:"upstream"`
+	Allow    []string `yaml:"allow"`
+}
+
+// Route restricts the registries which are consulted for an owner or plugin,
+// Owner and Plugin are path.Match patterns.
+type Route struct {
+	Owner      string   `yaml:"owner"`
+	Plugin     string   `yaml:"plugin"`
+	Registries []string `yaml:"registries"`
+}
+
+// Plugin configures plugins matching a `<owner>/<plugin>[:<version>]`
+// pattern, using path.Match. The first matching entry is used.
+type Plugin struct {
+	Match   string        `yaml:"match"`
+	Options []string      `yaml:"options"`
+	Timeout time.Duration `yaml:"timeout"`
+	// DefaultOptions are added unless the caller sets an option of the same
+	// name.
+	DefaultOptions []string `yaml:"default_options"`
+	// ForcedOptions are always added, replacing any the caller sets.
+	ForcedOptions []string `yaml:"forced_options"`
+	// DeniedOptions are patterns of options, or option names, which callers
+	// may not set, `*` matches any characters including `/`.
+	DeniedOptions []string `yaml:"denied_options"`
+	// PruneImports only sends the plugin the files it generates and their
+	// transitive imports, rather than the whole image.
+	PruneImports bool `yaml:"prune_imports"`
+	// CompilerVersion overrides the compiler version given to the plugin,
+	// including any recorded by its registry.
+	CompilerVersion string `yaml:"compiler_version"`
+	// Managed rewrites the file options of descriptors given to the plugin.
+	Managed *Managed `yaml:"managed"`
+	// Output post-processes the files generated by the plugin.
+	Output Output `yaml:"output"`
+}
+
+// Output configures how the files generated by a plugin are post-processed.
+type Output struct {
+	// StripPrefix is a directory removed from the start of file names.
+	StripPrefix string `yaml:"strip_prefix"`
+	// Prefix is a directory prepended to file names.
+	Prefix string `yaml:"prefix"`
+	// Exclude are patterns of files to drop, matched against their base
+	// name if the pattern has no `/`.
+	Exclude []string `yaml:"exclude"`
+}
+
+// Managed configures rewriting file options, like buf's managed mode.
+//
+//	managed:
+//	  disable:
+//	    - module: buf.build/googleapis/googleapis
+//	  override:
+//	    - file_option: go_package_prefix
+//	      value: github.com/acme/gen/go
+type Managed struct {
+	Disable  []ManagedDisable  `yaml:"disable"`
+	Override []ManagedOverride `yaml:"override"`
+}
+
+// ManagedDisable stops FileOption, or all options if unset, being rewritten
+// for files matching Module and Path.
+type ManagedDisable struct {
+	Module     string `yaml:"module"`
+	Path       string `yaml:"path"`
+	FileOption string `yaml:"file_option"`
+}
+
+// ManagedOverride sets FileOption to Value for files matching Module and
+// Path, the last matching override wins.
+type ManagedOverride struct {
+	Module     string `yaml:"module"`
+	Path       string `yaml:"path"`
+	FileOption string `yaml:"file_option"`
+	Value      string `yaml:"value"`
+}
+
+// Shutdown configures how the server shuts down on SIGINT or SIGTERM.
+type Shutdown struct {
+	// DrainTimeout is how long in-flight requests have to complete before
+	// their plugin executions are cancelled.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+}
+
+// Tracing configures exporting OpenTelemetry traces to an OTLP/HTTP
+// collector.
+type Tracing struct {
+	// Endpoint is the URL of the collector, e.g. `http://localhost:4318`.
+	// Traces are sent to its `/v1/traces` path, unless the URL has a path.
+	// Traces are only exported if set.
+	Endpoint string `yaml:"endpoint"`
+	// Headers are sent with every export, e.g. to authenticate with the
+	// collector.
+	Headers map[string]string `yaml:"headers"`
+	// SampleRatio is the fraction of traces sampled, from 0 to 1, unless the
+	// caller has already decided. Defaults to 1.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// Audit configures the audit log, recording every GenerateCode call as a
+// JSON line.
+type Audit struct {
+	// Output is `stdout`, `stderr`, or the path of a file the log is
+	// appended to. The audit log is only written if set.
+	Output string `yaml:"output"`
+	// MaxSizeMB is the size in megabytes a file is rotated at, defaulting to
+	// 100, 0 disables rotation.
+	MaxSizeMB int `yaml:"max_size_mb"`
+	// MaxBackups is the number of rotated files kept, defaulting to 10, 0
+	// keeps all of them.
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// Limits configures rate limits and concurrency quotas, limits which are not
+// set are not enforced.
+type Limits struct {
+	// Callers limits the GenerateCode calls of each caller, anonymous
+	// callers are limited by IP.
+	Callers *Rate `yaml:"callers"`
+	// Plugins limits how often each plugin version may be requested.
+	Plugins *Rate `yaml:"plugins"`
+
+	// Concurrency caps the plugin executions running at once, including
+	// looking the plugin up in its registry.
+	Concurrency int `yaml:"concurrency"`
+	// Queue is how many plugin executions may wait for a slot once
+	// Concurrency is reached, beyond which they are rejected.
+	Queue int `yaml:"queue"`
+	// QueueTimeout bounds how long a plugin execution waits for a slot, if
+	// set.
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+
+	// MaxRequestBytes bounds the size of requests, after decompression.
+	MaxRequestBytes int `yaml:"max_request_bytes"`
+	// MaxFiles bounds the number of files in a request's image.
+	MaxFiles int `yaml:"max_files"`
+	// MaxPlugins bounds the number of plugins a request may run.
+	MaxPlugins int `yaml:"max_plugins"`
+	// MaxOutputBytes bounds the total size of the files a request generates.
+	MaxOutputBytes int `yaml:"max_output_bytes"`
+}
+
+// Rate is a token bucket rate limit, allowing bursts of up to Burst
+// requests, defaulting to PerSecond.
+type Rate struct {
+	PerSecond float64 `yaml:"per_second"`
+	Burst     int     `yaml:"burst"`
+}
+
+// Cache configures where the server stores cached data.
+type Cache struct {
+	Dir string `yaml:"dir"`
+}
+
+// Auth configures how callers authenticate, if no method is configured all
+// callers are accepted.
+type Auth struct {
+	// Anonymous accepts callers which present no credentials at all.
+	Anonymous bool    `yaml:"anonymous"`
+	Tokens    []Token `yaml:"tokens"`
+	HMAC      *HMAC   `yaml:"hmac"`
+	JWT       *JWT    `yaml:"jwt"`
+}
+
+// LimitsEnabled reports whether any limit is configured.
+func (c *Config) LimitsEnabled() bool {
+	return c.Limits.Callers != nil || c.Limits.Plugins != nil || c.Limits.Concurrency > 0
+}
+
+// AuthEnabled reports whether any authentication method is configured,
+// including client certificates.
+func (c *Config) AuthEnabled() bool {
+	a := c.Auth
+
+	return len(a.Tokens) > 0 || a.HMAC != nil || a.JWT != nil || c.MutualTLS()
+}
+
+// MutualTLS reports whether client certificates are verified by any
+// listener.
+func (c *Config) MutualTLS() bool {
+	for _, l := range c.Listeners {
+		if l.TLS != nil && l.TLS.ClientCA != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Token is a static bearer token and the identity it grants.
+type Token struct {
+	Token   string   `yaml:"token"`
+	Subject string   `yaml:"subject"`
+	Groups  []string `yaml:"groups"`
+}
+
+// HMAC configures tokens signed with a shared secret, the first secret is
+// used to sign new tokens and all are accepted.
+type HMAC struct {
+	Secrets []string `yaml:"secrets"`
+}
+
+// JWT configures validation of JWTs against a local JWKS file.
+type JWT struct {
+	JWKSFile     string `yaml:"jwks_file"`
+	Issuer       string `yaml:"issuer"`
+	Audience     string `yaml:"audience"`
+	SubjectClaim string `yaml:"subject_claim"`
+	GroupsClaim  string `yaml:"groups_claim"`
+}
+
+// Policy grants the callers matching Subjects or Groups access to the plugins
+// matching Owners, Plugins and Versions. Once any policy is configured,
+// access to plugins not granted by a policy is denied.
+//
+// Policies are reloaded when the configuration file changes.
+type Policy struct {
+	Subjects []string `yaml:"subjects"`
+	Groups   []string `yaml:"groups"`
+	Owners   []string `yaml:"owners"`
+	Plugins  []string `yaml:"plugins"`
+	Versions []string `yaml:"versions"`
+}
+
+// Rules converts the configured policies into authorization rules.
+func (c *Config) Rules() []auth.Rule {
+	rules := make([]auth.Rule, len(c.Policies))
+	for i, p := range c.Policies {
+		rules[i] = auth.Rule{
+			Subjects: p.Subjects,
+			Groups:   p.Groups,
+			Owners:   p.Owners,
+			Plugins:  p.Plugins,
+			Versions: p.Versions,
+		}
+	}
+
+	return rules
+}
+
+// defaults returns the configuration values used unless configured
+// otherwise, whether or not a file is given.
+func defaults() *Config {
+	return &Config{
+		Shutdown: Shutdown{DrainTimeout: 30 * time.Second},
+		Tracing:  Tracing{SampleRatio: 1},
+		Audit:    Audit{MaxSizeMB: 100, MaxBackups: 10},
+	}
+}
+
+// Default is the configuration used when no file is given.
+func Default() *Config {
+	c := defaults()
+	c.Listeners = []Listener{
+		{Mode: "h2c", Address: "0.0.0.0:443"},
+	}
+	c.Registries = []Registry{
+		{Type: "docker", Path: os.Getenv("CODEGENERATOR_REGISTRY_PATH")},
+	}
+
+	return c
+}
+
+// Load reads the configuration file at "path".
+//
+// Environment variables are interpolated before the file is parsed, using
+// `${VAR}` or `${VAR:-default}`, a literal `$` is written as `$$`. Unknown
+// fields are rejected.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	var missing []string
+	expanded := os.Expand(string(b), func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+
+		name, fallback, hasFallback := strings.Cut(name, ":-")
+		v, ok := os.LookupEnv(name)
+		switch {
+		case hasFallback && v == "":
+			return fallback
+		case !ok:
+			missing = append(missing, name)
+		}
+
+		return v
+	})
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("reading config %s: environment variables not set: %s", path, strings.Join(missing, ", "))
+	}
+
+	c := defaults()
+
+	dec := yaml.NewDecoder(bytes.NewReader([]byte(expanded)))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+
+	return c, nil
+}
+
+// Validate checks the configuration is complete and consistent, reporting
+// all problems found.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if len(c.Listeners) == 0 {
+		fail("listeners", "at least one listener must be configured")
+	}
+
+	addresses, api := map[string]bool{}, false
+	for i, l := range c.Listeners {
+		field := fmt.Sprintf("listeners[%d]", i)
+
+		for j, endpoint := range l.Serve {
+			switch endpoint {
+			case "api", "health", "metrics":
+			default:
+				fail(fmt.Sprintf("%s.serve[%d]", field, j), "unknown endpoint %q, expected one of api, health or metrics", endpoint)
+			}
+		}
+
+		api = api || l.Serves("api")
+
+		switch l.Mode {
+		case "tls":
+			if l.TLS == nil {
+				fail(field+".tls", "must be set for tls listeners")
+			}
+		case "h2c", "unix":
+			if l.TLS != nil {
+				fail(field+".tls", "is only supported for tls listeners")
+			}
+		case "":
+			fail(field+".mode", "must be set")
+		default:
+			fail(field+".mode", "unknown mode %q, expected one of tls, h2c or unix", l.Mode)
+		}
+
+		if l.Address == "" {
+			fail(field+".address", "must be set")
+		} else if addresses[l.Address] {
+			fail(field+".address", "duplicate address %q", l.Address)
+		}
+		addresses[l.Address] = true
+
+		if tls := l.TLS; tls != nil {
+			if tls.Cert == "" || tls.Key == "" {
+				fail(field+".tls", "cert and key must both be set")
+			}
+
+			switch tls.ClientAuth {
+			case "", "require", "optional":
+			default:
+				fail(field+".tls.client_auth", "unknown value %q, expected require or optional", tls.ClientAuth)
+			}
+
+			switch tls.ClientSubject {
+			case "", "uri", "dns", "email", "cn":
+			default:
+				fail(field+".tls.client_subject", "unknown value %q, expected one of uri, dns, email or cn", tls.ClientSubject)
+			}
+
+			if tls.ClientCA == "" && (tls.ClientAuth != "" || tls.ClientSubject != "") {
+				fail(field+".tls.client_ca", "must be set to authenticate client certificates")
+			}
+		}
+	}
+
+	if len(c.Listeners) > 0 && !api {
+		fail("listeners", "at least one listener must serve the api")
+	}
+
+	if len(c.Registries) == 0 {
+		fail("registries", "at least one registry must be configured")
+	}
+
+	// Docker registries without pull provide every plugin, leaving docker to
+	// pull missing images, so registries after them are never consulted.
+	names, providesAll := map[string]bool{}, map[string]bool{}
+	for i, r := range c.Registries {
+		field := fmt.Sprintf("registries[%d]", i)
+
+		if r.Type == "docker" && r.Pull == nil {
+			providesAll[r.RegistryName()] = true
+
+			if i < len(c.Registries)-1 {
+				fail(field, "docker registries without pull provide every plugin, so must be last")
+			}
+		}
+
+		switch r.Type {
+		case "local", "oci":
+			if r.Path == "" {
+				fail(field+".path", "must be set for %s registries", r.Type)
+			}
+		case "docker", "inprocess":
+		case "":
+			fail(field+".type", "must be set")
+		default:
+			fail(field+".type", "unknown type %q, expected one of docker, local, oci or inprocess", r.Type)
+		}
+
+		if r.Pull != nil {
+			if r.Type != "docker" {
+				fail(field+".pull", "is only supported for docker registries")
+			}
+
+			if r.Pull.Upstream == "" {
+				fail(field+".pull.upstream", "must be set")
+			}
+
+			for j, pattern := range r.Pull.Allow {
+				if _, err := path.Match(pattern, ""); err != nil {
+					fail(fmt.Sprintf("%s.pull.allow[%d]", field, j), "invalid pattern %q", pattern)
+				}
+			}
+		}
+
+		name := r.RegistryName()
+		if names[name] {
+			fail(field+".name", "duplicate registry name %q", name)
+		}
+		names[name] = true
+	}
+
+	for i, r := range c.Routes {
+		field := fmt.Sprintf("routes[%d]", i)
+
+		if r.Owner == "" {
+			fail(field+".owner", "must be set")
+		} else if _, err := path.Match(r.Owner, ""); err != nil {
+			fail(field+".owner", "invalid pattern %q", r.Owner)
+		}
+
+		if _, err := path.Match(r.Plugin, ""); err != nil {
+			fail(field+".plugin", "invalid pattern %q", r.Plugin)
+		}
+
+		if len(r.Registries) == 0 {
+			fail(field+".registries", "must not be empty")
+		}
+
+		for j, name := range r.Registries {
+			if !names[name] {
+				fail(field+".registries", "unknown registry %q", name)
+			}
+
+			if providesAll[name] && j < len(r.Registries)-1 {
+				fail(field+".registries", "docker registry %q without pull provides every plugin, so must be last", name)
+			}
+		}
+	}
+
+	if c.CompilerVersion != "" {
+		if _, err := plugin.ParseCompilerVersion(c.CompilerVersion); err != nil {
+			fail("compiler_version", "%v", err)
+		}
+	}
+
+	c.pluginConfigs = make([]codegenerator.PluginConfig, len(c.Plugins))
+	for i, p := range c.Plugins {
+		field := fmt.Sprintf("plugins[%d]", i)
+
+		config := codegenerator.PluginConfig{
+			Options:        p.Options,
+			DefaultOptions: p.DefaultOptions,
+			ForcedOptions:  p.ForcedOptions,
+			DeniedOptions:  p.DeniedOptions,
+			Timeout:        p.Timeout,
+			PruneImports:   p.PruneImports,
+			Output:         codegenerator.Output(p.Output),
+		}
+
+		if p.Match == "" {
+			fail(field+".match", "must be set")
+		} else if _, err := path.Match(p.Match, ""); err != nil {
+			fail(field+".match", "invalid pattern %q", p.Match)
+		}
+
+		if p.Timeout < 0 {
+			fail(field+".timeout", "must not be negative")
+		}
+
+		if p.CompilerVersion != "" {
+			var err error
+			if config.CompilerVersion, err = plugin.ParseCompilerVersion(p.CompilerVersion); err != nil {
+				fail(field+".compiler_version", "%v", err)
+			}
+		}
+
+		for _, dir := range []struct{ field, path string }{
+			{"output.strip_prefix", p.Output.StripPrefix},
+			{"output.prefix", p.Output.Prefix},
+		} {
+			if dir.path != "" && !filepath.IsLocal(dir.path) {
+				fail(field+"."+dir.field, "must be a relative path within the output directory")
+			}
+		}
+
+		for j, pattern := range p.Output.Exclude {
+			if _, err := path.Match(pattern, ""); err != nil {
+				fail(fmt.Sprintf("%s.output.exclude[%d]", field, j), "invalid pattern %q", pattern)
+			}
+		}
+
+		if p.Managed != nil {
+			config.Managed = &managed.Config{}
+
+			for j, d := range p.Managed.Disable {
+				if err := managed.CheckDisable(d.FileOption); err != nil {
+					fail(fmt.Sprintf("%s.managed.disable[%d].file_option", field, j), "%v", err)
+				}
+
+				config.Managed.Disable = append(config.Managed.Disable, managed.Disable(d))
+			}
+
+			for j, o := range p.Managed.Override {
+				if err := managed.CheckOverride(o.FileOption, o.Value); err != nil {
+					fail(fmt.Sprintf("%s.managed.override[%d]", field, j), "%v", err)
+				}
+
+				config.Managed.Override = append(config.Managed.Override, managed.Override(o))
+			}
+		}
+
+		config.Compile()
+		c.pluginConfigs[i] = config
+	}
+
+	for i, t := range c.Auth.Tokens {
+		field := fmt.Sprintf("auth.tokens[%d]", i)
+
+		if t.Token == "" {
+			fail(field+".token", "must be set")
+		}
+
+		if t.Subject == "" {
+			fail(field+".subject", "must be set")
+		}
+	}
+
+	if h := c.Auth.HMAC; h != nil {
+		if len(h.Secrets) == 0 {
+			fail("auth.hmac.secrets", "must not be empty")
+		}
+
+		for i, secret := range h.Secrets {
+			if len(secret) < 32 {
+				fail(fmt.Sprintf("auth.hmac.secrets[%d]", i), "must be at least 32 bytes")
+			}
+		}
+	}
+
+	if j := c.Auth.JWT; j != nil && j.JWKSFile == "" {
+		fail("auth.jwt.jwks_file", "must be set")
+	}
+
+	for i, p := range c.Policies {
+		field := fmt.Sprintf("policies[%d]", i)
+
+		for j, pattern := range p.Owners {
+			if _, err := path.Match(pattern, ""); err != nil {
+				fail(fmt.Sprintf("%s.owners[%d]", field, j), "invalid pattern %q", pattern)
+			}
+		}
+
+		for j, pattern := range p.Plugins {
+			if _, err := path.Match(pattern, ""); err != nil {
+				fail(fmt.Sprintf("%s.plugins[%d]", field, j), "invalid pattern %q", pattern)
+			}
+		}
+
+		for j, pattern := range p.Versions {
+			if _, err := path.Match(pattern, ""); err != nil {
+				fail(fmt.Sprintf("%s.versions[%d]", field, j), "invalid pattern %q", pattern)
+			}
+		}
+	}
+
+	if c.Shutdown.DrainTimeout < 0 {
+		fail("shutdown.drain_timeout", "must not be negative")
+	}
+
+	if c.Tracing.Endpoint != "" {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("tracing.endpoint", "must be an http or https URL, got %q", c.Tracing.Endpoint)
+		}
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		fail("tracing.sample_ratio", "must be between 0 and 1")
+	}
+
+	if c.Audit.MaxSizeMB < 0 {
+		fail("audit.max_size_mb", "must not be negative")
+	}
+
+	if c.Audit.MaxBackups < 0 {
+		fail("audit.max_backups", "must not be negative")
+	}
+
+	rates := []struct {
+		field string
+		rate  *Rate
+	}{
+		{"limits.callers", c.Limits.Callers},
+		{"limits.plugins", c.Limits.Plugins},
+	}
+	for _, r := range rates {
+		if r.rate == nil {
+			continue
+		}
+
+		if r.rate.PerSecond <= 0 {
+			fail(r.field+".per_second", "must be positive")
+		}
+
+		if r.rate.Burst < 0 {
+			fail(r.field+".burst", "must not be negative")
+		}
+	}
+
+	if c.Limits.Concurrency < 0 {
+		fail("limits.concurrency", "must not be negative")
+	}
+
+	if c.Limits.Queue < 0 {
+		fail("limits.queue", "must not be negative")
+	} else if c.Limits.Queue > 0 && c.Limits.Concurrency == 0 {
+		fail("limits.queue", "requires limits.concurrency to be set")
+	}
+
+	if c.Limits.QueueTimeout < 0 {
+		fail("limits.queue_timeout", "must not be negative")
+	}
+
+	if c.Limits.MaxRequestBytes < 0 {
+		fail("limits.max_request_bytes", "must not be negative")
+	}
+
+	if c.Limits.MaxFiles < 0 {
+		fail("limits.max_files", "must not be negative")
+	}
+
+	if c.Limits.MaxPlugins < 0 {
+		fail("limits.max_plugins", "must not be negative")
+	}
+
+	if c.Limits.MaxOutputBytes < 0 {
+		fail("limits.max_output_bytes", "must not be negative")
+	}
+
+	return errors.Join(errs...)
+}
+
+// RegistryName is the name routes use to refer to the registry.
+func (r Registry) RegistryName() string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	return r.Type
+}
+
+// CacheDir is the cache directory of the registry, or empty if caching is not
+// configured.
+func (c *Config) CacheDir(r Registry) string {
+	if r.Cache != "" || c.Cache.Dir == "" {
+		return r.Cache
+	}
+
+	return filepath.Join(c.Cache.Dir, r.RegistryName())
+}
+
+// PluginConfig returns the configuration of the first plugin entry matching
+// "ref", Validate must have been called first.
+func (c *Config) PluginConfig(ref *v1alpha1.CuratedPluginReference) codegenerator.PluginConfig {
+	name := ref.GetOwner() + "/" + ref.GetName()
+	versioned := name + ":" + ref.GetVersion()
+
+	for i, p := range c.Plugins {
+		if ok, _ := path.Match(p.Match, name); !ok {
+			if ok, _ := path.Match(p.Match, versioned); !ok {
+				continue
+			}
+		}
+
+		return c.pluginConfigs[i]
+	}
+
+	return codegenerator.PluginConfig{}
+}
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "codegenerator.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadInterpolation(t *testing.T) {
+	t.Setenv("PLUGINS_DIR", "/srv/plugins")
+	t.Setenv("EMPTY", "")
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "variable", path: "${PLUGINS_DIR}", want: "/srv/plugins"},
+		{name: "within a value", path: "${PLUGINS_DIR}/acme", want: "/srv/plugins/acme"},
+		{name: "default unused", path: "${PLUGINS_DIR:-/default}", want: "/srv/plugins"},
+		{name: "default of unset", path: "${UNSET_PLUGINS_DIR:-/default}", want: "/default"},
+		{name: "default of empty", path: "${EMPTY:-/default}", want: "/default"},
+		{name: "empty default", path: "/srv${UNSET_PLUGINS_DIR:-}", want: "/srv"},
+		{name: "set but empty", path: "/srv${EMPTY}", want: "/srv"},
+		{name: "escaped", path: "/srv/$${PLUGINS_DIR}", want: "/srv/${PLUGINS_DIR}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Load(writeConfig(t, "registries:\n  - type: local\n    path: "+tt.path+"\n"))
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+
+			if got := c.Registries[0].Path; got != tt.want {
+				t.Fatalf("path = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		// wantErr are substrings of the error.
+		wantErr []string
+	}{
+		{
+			name:     "unset variables",
+			contents: "registries:\n  - type: ${UNSET_TYPE}\n    path: ${UNSET_PATH}\n",
+			wantErr:  []string{"environment variables not set: UNSET_TYPE, UNSET_PATH"},
+		},
+		{
+			name:     "unknown field",
+			contents: "registries:\n  - type: local\n    paths: /srv\n",
+			wantErr:  []string{"field paths not found"},
+		},
+		{
+			name:     "invalid yaml",
+			contents: "registries: [",
+			wantErr:  []string{"parsing config"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tt.contents))
+			if err == nil {
+				t.Fatal("Load() error = nil, want an error")
+			}
+
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Load() error = %v, want %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	c, err := Load(writeConfig(t, "listeners:\n  - mode: h2c\n    address: 127.0.0.1:8080\nregistries:\n  - type: inprocess\n"))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	if c.Shutdown.DrainTimeout != 30*time.Second || c.Tracing.SampleRatio != 1 || c.Audit.MaxBackups != 10 {
+		t.Fatalf("Load() = %+v, want the defaults", c)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		return &Config{
+			Listeners:  []Listener{{Mode: "h2c", Address: "127.0.0.1:8080"}},
+			Registries: []Registry{{Type: "local", Path: "/srv/plugins"}, {Type: "docker"}},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		config func(c *Config)
+		// wantErr are the fields reported, all of which must be.
+		wantErr []string
+	}{
+		{
+			name:   "valid",
+			config: func(*Config) {},
+		},
+		{
+			name: "all problems reported",
+			config: func(c *Config) {
+				c.Listeners = append(c.Listeners, Listener{Mode: "quic", Address: "127.0.0.1:8080"})
+				c.Registries = append(c.Registries, Registry{Type: "oci"})
+				c.Plugins = []Plugin{{Match: "acme/*", Timeout: -time.Second, CompilerVersion: "latest"}}
+				c.CompilerVersion = "1.2.3.4"
+			},
+			wantErr: []string{
+				"listeners[1].mode",
+				"listeners[1].address",
+				"registries[1]:",
+				"registries[2].path",
+				"plugins[0].timeout",
+				"plugins[0].compiler_version",
+				"compiler_version:",
+			},
+		},
+		{
+			name: "docker registry without pull not last",
+			config: func(c *Config) {
+				c.Registries = []Registry{{Type: "docker"}, {Type: "local", Path: "/srv/plugins"}}
+				c.Routes = []Route{{Owner: "acme", Registries: []string{"docker", "local"}}}
+			},
+			wantErr: []string{"registries[0]:", "routes[0].registries"},
+		},
+		{
+			name: "no listener serving the api",
+			config: func(c *Config) {
+				c.Listeners[0].Serve = []string{"health", "metrics", "admin"}
+			},
+			wantErr: []string{"listeners:", "listeners[0].serve[2]"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.config(c)
+
+			err := c.Validate()
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatal("Validate() error = nil, want an error")
+			}
+
+			for _, field := range tt.wantErr {
+				if !strings.Contains(err.Error(), field) {
+					t.Errorf("Validate() error = %v, want %s reported", err, field)
+				}
+			}
+		})
+	}
+}
+
+func TestPluginConfig(t *testing.T) {
+	c := &Config{
+		Listeners:  []Listener{{Mode: "h2c", Address: "127.0.0.1:8080"}},
+		Registries: []Registry{{Type: "inprocess"}},
+		Plugins: []Plugin{
+			{Match: "acme/protoc-gen-x:v1.*", Timeout: time.Second},
+			{Match: "acme/*", Timeout: time.Minute, CompilerVersion: "25.1"},
+		},
+	}
+
+	if err := c.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	ref := func(name, version string) *v1alpha1.CuratedPluginReference {
+		return &v1alpha1.CuratedPluginReference{Owner: "acme", Name: name, Version: version}
+	}
+
+	// The first matching entry is used, by name or by version.
+	if got := c.PluginConfig(ref("protoc-gen-x", "v1.0.0")); got.Timeout != time.Second {
+		t.Errorf("PluginConfig(v1) timeout = %v, want 1s", got.Timeout)
+	}
+
+	got := c.PluginConfig(ref("protoc-gen-x", "v2.0.0"))
+	if got.Timeout != time.Minute || got.CompilerVersion.GetMajor() != 25 || got.CompilerVersion.GetMinor() != 1 {
+		t.Errorf("PluginConfig(v2) = %+v, want the acme/* entry", got)
+	}
+
+	if got := c.PluginConfig(&v1alpha1.CuratedPluginReference{Owner: "other", Name: "protoc-gen-x"}); got.Timeout != 0 {
+		t.Errorf("PluginConfig(other) = %+v, want no configuration", got)
+	}
+}
@@ -0,0 +1,149 @@
+package codegenerator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	imagev1 "github.com/CGA1123/codegenerator/gen/buf/alpha/image/v1"
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+	"github.com/CGA1123/codegenerator/metrics"
+)
+
+// flights collapses concurrent identical plugin executions into one, shared
+// by all callers waiting on it.
+//
+// Unlike singleflight, an execution is not bound to the context of the
+// caller which started it: it is only cancelled once every caller waiting on
+// it has gone away.
+type flights struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+type flight struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+
+	res *pluginpb.CodeGeneratorResponse
+	err error
+}
+
+// imageDigest hashes "image", identifying it across requests.
+func imageDigest(image *imagev1.Image) ([]byte, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(image)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling image: %w", err)
+	}
+
+	digest := sha256.Sum256(b)
+
+	return digest[:], nil
+}
+
+// flightKey identifies identical executions, by plugin, the digest of the
+// image the request was built from, and everything else which shapes the
+// request built from it, so that the request itself is never encoded.
+func flightKey(ref *v1alpha1.CuratedPluginReference, digest []byte, pluginRequest *v1alpha1.PluginGenerationRequest, config PluginConfig, req *pluginpb.CodeGeneratorRequest) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s/%s:%s\x00%x\x00", ref.GetOwner(), ref.GetName(), ref.GetVersion(), digest)
+	fmt.Fprintf(h, "%t\x00%t\x00%t\x00", pluginRequest.GetIncludeImports(), pluginRequest.GetIncludeWellKnownTypes(), config.PruneImports)
+	fmt.Fprintf(h, "%q\x00", req.GetParameter())
+
+	if v := req.GetCompilerVersion(); v != nil {
+		fmt.Fprintf(h, "%d.%d.%d-%q", v.GetMajor(), v.GetMinor(), v.GetPatch(), v.GetSuffix())
+	}
+	h.Write([]byte{0})
+
+	if config.Managed != nil {
+		fmt.Fprintf(h, "%q", *config.Managed)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// do runs "fn", unless an execution with the same key is already in flight,
+// in which case its result is shared.
+//
+// "fn" is given a context carrying the values of the first caller's, which is
+// cancelled once no caller is waiting on the result. As the execution may
+// outlive its callers, it is registered with "track" before it starts. Each
+// caller receives its own copy of the response, a panic in "fn" is returned
+// as an `internal` error.
+func (f *flights) do(ctx context.Context, key string, track func(context.Context) (context.Context, func()), fn func(context.Context) (*pluginpb.CodeGeneratorResponse, error)) (*pluginpb.CodeGeneratorResponse, error) {
+	f.mu.Lock()
+	if f.flights == nil {
+		f.flights = map[string]*flight{}
+	}
+
+	fl, shared := f.flights[key]
+	if !shared {
+		flightCtx, untrack := track(context.WithoutCancel(ctx))
+		flightCtx, cancel := context.WithCancel(flightCtx)
+
+		fl = &flight{done: make(chan struct{}), cancel: cancel}
+		f.flights[key] = fl
+
+		go func() {
+			defer untrack()
+			defer cancel()
+
+			defer func() {
+				if r := recover(); r != nil {
+					slog.Error("plugin execution panicked", "panic", r, "stack", string(debug.Stack()))
+					fl.res, fl.err = nil, connect.NewError(connect.CodeInternal, fmt.Errorf("plugin execution panicked: %v", r))
+				}
+
+				f.mu.Lock()
+				f.forget(key, fl)
+				f.mu.Unlock()
+
+				close(fl.done)
+			}()
+
+			fl.res, fl.err = fn(flightCtx)
+		}()
+	}
+	fl.waiters++
+	f.mu.Unlock()
+
+	metrics.CacheRequests.WithLabelValues("inflight", metrics.CacheResult(shared)).Inc()
+
+	select {
+	case <-fl.done:
+		if fl.err != nil || fl.res == nil {
+			return nil, fl.err
+		}
+
+		return proto.Clone(fl.res).(*pluginpb.CodeGeneratorResponse), nil
+	case <-ctx.Done():
+		f.mu.Lock()
+		fl.waiters--
+		if fl.waiters == 0 {
+			// Nobody is left waiting, so stop the execution, and make sure
+			// later callers start a new one rather than joining it.
+			fl.cancel()
+			f.forget(key, fl)
+		}
+		f.mu.Unlock()
+
+		return nil, ctx.Err()
+	}
+}
+
+// forget removes "fl" from the flights in progress, if it is still the
+// flight for "key". It must be called with the lock held.
+func (f *flights) forget(key string, fl *flight) {
+	if f.flights[key] == fl {
+		delete(f.flights, key)
+	}
+}
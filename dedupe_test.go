@@ -0,0 +1,351 @@
+package codegenerator
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	imagev1 "github.com/CGA1123/codegenerator/gen/buf/alpha/image/v1"
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+	"github.com/CGA1123/codegenerator/managed"
+)
+
+// tracker counts the executions in flight, as Service.track would.
+type tracker struct {
+	inflight atomic.Int32
+}
+
+func (tr *tracker) track(ctx context.Context) (context.Context, func()) {
+	tr.inflight.Add(1)
+
+	return ctx, func() { tr.inflight.Add(-1) }
+}
+
+// waitFor polls until "cond" holds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (f *flights) waiters(key string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if fl, ok := f.flights[key]; ok {
+		return fl.waiters
+	}
+
+	return 0
+}
+
+func TestFlightsShareExecution(t *testing.T) {
+	var f flights
+	var tr tracker
+	var runs atomic.Int32
+	release := make(chan struct{})
+
+	fn := func(context.Context) (*pluginpb.CodeGeneratorResponse, error) {
+		runs.Add(1)
+		<-release
+
+		return &pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{{Name: proto.String("a.txt")}}}, nil
+	}
+
+	const callers = 5
+	results := make([]*pluginpb.CodeGeneratorResponse, callers)
+	var wg sync.WaitGroup
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res, err := f.do(context.Background(), "key", tr.track, fn)
+			if err != nil {
+				t.Errorf("do() error = %v", err)
+			}
+			results[i] = res
+		}()
+	}
+
+	waitFor(t, "all callers to wait", func() bool { return f.waiters("key") == callers })
+	close(release)
+	wg.Wait()
+
+	if got := runs.Load(); got != 1 {
+		t.Fatalf("executions = %d, want 1", got)
+	}
+
+	for i, res := range results {
+		if res.GetFile()[0].GetName() != "a.txt" {
+			t.Fatalf("results[%d] = %v", i, res)
+		}
+
+		// Callers may rewrite their response, so each has its own.
+		for _, other := range results[:i] {
+			if res == other {
+				t.Fatal("callers share a response")
+			}
+		}
+	}
+
+	waitFor(t, "the execution to be untracked", func() bool { return tr.inflight.Load() == 0 })
+
+	// Once finished, an identical call runs again.
+	if _, err := f.do(context.Background(), "key", tr.track, fn); err != nil {
+		t.Fatalf("do() error = %v", err)
+	}
+
+	if got := runs.Load(); got != 2 {
+		t.Fatalf("executions = %d, want 2", got)
+	}
+}
+
+func TestFlightsCancellation(t *testing.T) {
+	var f flights
+	var tr tracker
+	started := make(chan context.Context, 1)
+	release := make(chan struct{})
+
+	fn := func(ctx context.Context) (*pluginpb.CodeGeneratorResponse, error) {
+		started <- ctx
+
+		select {
+		case <-release:
+			return &pluginpb.CodeGeneratorResponse{}, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	type result struct {
+		res *pluginpb.CodeGeneratorResponse
+		err error
+	}
+
+	call := func(ctx context.Context) chan result {
+		ch := make(chan result, 1)
+		go func() {
+			res, err := f.do(ctx, "key", tr.track, fn)
+			ch <- result{res, err}
+		}()
+
+		return ch
+	}
+
+	// The first caller starts the execution.
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	first := call(firstCtx)
+	execCtx := <-started
+
+	secondCtx, cancelSecond := context.WithCancel(context.Background())
+	defer cancelSecond()
+	second := call(secondCtx)
+
+	thirdCtx, cancelThird := context.WithCancel(context.Background())
+	defer cancelThird()
+	third := call(thirdCtx)
+
+	waitFor(t, "all callers to wait", func() bool { return f.waiters("key") == 3 })
+
+	// The caller which started the execution going away fails only itself.
+	cancelFirst()
+	if r := <-first; !errors.Is(r.err, context.Canceled) {
+		t.Fatalf("first caller error = %v, want canceled", r.err)
+	}
+
+	// As does another, while a caller is still waiting.
+	cancelSecond()
+	if r := <-second; !errors.Is(r.err, context.Canceled) {
+		t.Fatalf("second caller error = %v, want canceled", r.err)
+	}
+
+	if execCtx.Err() != nil {
+		t.Fatal("execution was cancelled while a caller was still waiting")
+	}
+
+	close(release)
+	if r := <-third; r.err != nil || r.res == nil {
+		t.Fatalf("third caller = %v, %v, want a response", r.res, r.err)
+	}
+}
+
+func TestFlightsCancelledOnceAbandoned(t *testing.T) {
+	var f flights
+	var tr tracker
+	started := make(chan context.Context, 2)
+
+	fn := func(ctx context.Context) (*pluginpb.CodeGeneratorResponse, error) {
+		started <- ctx
+		<-ctx.Done()
+
+		return nil, ctx.Err()
+	}
+
+	ctxs := make([]context.CancelFunc, 2)
+	errs := make(chan error, 2)
+	for i := range ctxs {
+		ctx, cancel := context.WithCancel(context.Background())
+		ctxs[i] = cancel
+
+		go func() {
+			_, err := f.do(ctx, "key", tr.track, fn)
+			errs <- err
+		}()
+
+		if i == 0 {
+			<-started
+		}
+	}
+
+	waitFor(t, "all callers to wait", func() bool { return f.waiters("key") == 2 })
+
+	ctxs[0]()
+	<-errs
+	ctxs[1]()
+	<-errs
+
+	// The execution is stopped, and untracked once it returns.
+	waitFor(t, "the execution to be untracked", func() bool { return tr.inflight.Load() == 0 })
+
+	// A later identical call starts a new execution, rather than joining
+	// the cancelled one.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() { _, _ = f.do(ctx, "key", tr.track, fn) }()
+
+	select {
+	case execCtx := <-started:
+		if execCtx.Err() != nil {
+			t.Fatal("new execution was already cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no new execution was started")
+	}
+}
+
+func TestFlightsResults(t *testing.T) {
+	tests := []struct {
+		name     string
+		fn       func(context.Context) (*pluginpb.CodeGeneratorResponse, error)
+		wantCode connect.Code
+	}{
+		{
+			name: "no response",
+			fn: func(context.Context) (*pluginpb.CodeGeneratorResponse, error) {
+				return nil, nil
+			},
+		},
+		{
+			name: "error",
+			fn: func(context.Context) (*pluginpb.CodeGeneratorResponse, error) {
+				return nil, connect.NewError(connect.CodeUnavailable, errors.New("unavailable"))
+			},
+			wantCode: connect.CodeUnavailable,
+		},
+		{
+			name: "panic",
+			fn: func(context.Context) (*pluginpb.CodeGeneratorResponse, error) {
+				panic("boom")
+			},
+			wantCode: connect.CodeInternal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f flights
+			var tr tracker
+
+			res, err := f.do(context.Background(), "key", tr.track, tt.fn)
+			if res != nil {
+				t.Fatalf("do() = %v, want no response", res)
+			}
+
+			if tt.wantCode == 0 && err != nil {
+				t.Fatalf("do() error = %v", err)
+			}
+
+			if tt.wantCode != 0 && connect.CodeOf(err) != tt.wantCode {
+				t.Fatalf("do() error = %v, want %v", err, tt.wantCode)
+			}
+
+			waitFor(t, "the execution to be untracked", func() bool { return tr.inflight.Load() == 0 })
+		})
+	}
+}
+
+func TestFlightKey(t *testing.T) {
+	ref := &v1alpha1.CuratedPluginReference{Owner: "acme", Name: "protoc-gen-x", Version: "v1.0.0"}
+	digest, err := imageDigest(&imagev1.Image{File: []*imagev1.ImageFile{{Name: proto.String("a.proto")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pluginRequest := &v1alpha1.PluginGenerationRequest{PluginReference: ref}
+	req := &pluginpb.CodeGeneratorRequest{
+		Parameter:       proto.String("a=b"),
+		CompilerVersion: &pluginpb.Version{Major: proto.Int32(5), Minor: proto.Int32(29)},
+	}
+	key := flightKey(ref, digest, pluginRequest, PluginConfig{}, req)
+
+	if other := flightKey(ref, digest, pluginRequest, PluginConfig{Options: []string{"ignored"}}, proto.Clone(req).(*pluginpb.CodeGeneratorRequest)); other != key {
+		t.Fatal("identical executions have different keys")
+	}
+
+	otherDigest, err := imageDigest(&imagev1.Image{File: []*imagev1.ImageFile{{Name: proto.String("b.proto")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	differ := map[string]func() string{
+		"plugin": func() string {
+			ref := &v1alpha1.CuratedPluginReference{Owner: "acme", Name: "protoc-gen-x", Version: "v1.0.1"}
+			return flightKey(ref, digest, pluginRequest, PluginConfig{}, req)
+		},
+		"image": func() string {
+			return flightKey(ref, otherDigest, pluginRequest, PluginConfig{}, req)
+		},
+		"imports": func() string {
+			return flightKey(ref, digest, &v1alpha1.PluginGenerationRequest{IncludeImports: proto.Bool(true)}, PluginConfig{}, req)
+		},
+		"parameter": func() string {
+			req := proto.Clone(req).(*pluginpb.CodeGeneratorRequest)
+			req.Parameter = proto.String("a=c")
+			return flightKey(ref, digest, pluginRequest, PluginConfig{}, req)
+		},
+		"compiler version": func() string {
+			req := proto.Clone(req).(*pluginpb.CodeGeneratorRequest)
+			req.CompilerVersion.Suffix = proto.String("rc1")
+			return flightKey(ref, digest, pluginRequest, PluginConfig{}, req)
+		},
+		"pruning": func() string {
+			return flightKey(ref, digest, pluginRequest, PluginConfig{PruneImports: true}, req)
+		},
+		"managed mode": func() string {
+			config := PluginConfig{Managed: &managed.Config{Override: []managed.Override{{FileOption: "go_package_prefix", Value: "example.com/gen"}}}}
+			return flightKey(ref, digest, pluginRequest, config, req)
+		},
+	}
+
+	for name, other := range differ {
+		if other() == key {
+			t.Errorf("executions differing by %s have the same key", name)
+		}
+	}
+}
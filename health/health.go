@@ -0,0 +1,108 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"connectrpc.com/grpchealth"
+)
+
+// checkTimeout bounds how long a readiness check may take, so a hanging
+// dependency is reported as not ready rather than hanging the probe.
+const checkTimeout = 5 * time.Second
+
+// Check reports why a component of the server is not ready to serve
+// requests, or nil if it is.
+type Check func(ctx context.Context) error
+
+// Checker decides whether the server is ready to serve requests, from the
+// checks added to it.
+//
+// It serves the readiness endpoint over HTTP, and checks health for the
+// `grpc.health.v1.Health` service served by grpchealth.NewHandler.
+type Checker struct {
+	services []string
+
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// NewChecker builds a Checker reporting the health of "services".
+func NewChecker(services ...string) *Checker {
+	return &Checker{services: services}
+}
+
+// Add adds a check, the server is only ready once all checks pass.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Ready runs all checks, returning why the server is not ready, if it is not.
+func (c *Checker) Ready(ctx context.Context) error {
+	c.mu.RLock()
+	checks := slices.Clone(c.checks)
+	c.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	var errs []error
+	for _, nc := range checks {
+		if err := nc.check(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", nc.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ServeHTTP serves the readiness endpoint, responding `503 Service
+// Unavailable` with the reasons when the server is not ready.
+func (c *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	if err := c.Ready(r.Context()); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, err)
+		return
+	}
+
+	fmt.Fprintln(w, "ok")
+}
+
+// Live serves the liveness endpoint, the server is live as long as it is
+// able to respond.
+func Live() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+}
+
+// Check implements grpchealth.Checker, the health of the server and of each
+// of "services" is its readiness.
+func (c *Checker) Check(ctx context.Context, req *grpchealth.CheckRequest) (*grpchealth.CheckResponse, error) {
+	if req.Service != "" && !slices.Contains(c.services, req.Service) {
+		return nil, connect.NewError(connect.CodeNotFound, fmt.Errorf("unknown service %q", req.Service))
+	}
+
+	if err := c.Ready(ctx); err != nil {
+		return &grpchealth.CheckResponse{Status: grpchealth.StatusNotServing}, nil
+	}
+
+	return &grpchealth.CheckResponse{Status: grpchealth.StatusServing}, nil
+}
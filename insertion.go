@@ -0,0 +1,114 @@
+package codegenerator
+
+import (
+	"fmt"
+	"strings"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+)
+
+// insertions applies the insertion points of the plugins of a request to the
+// files generated earlier in the request, as protoc does.
+type insertions struct {
+	files map[string]*pluginpb.CodeGeneratorResponse_File
+}
+
+// apply merges the files generated by the plugin "ref" which continue a
+// previous file, and inserts those with an insertion point into the files
+// they name, dropping them from "res".
+func (in *insertions) apply(ref *v1alpha1.CuratedPluginReference, res *pluginpb.CodeGeneratorResponse) error {
+	if in.files == nil {
+		in.files = map[string]*pluginpb.CodeGeneratorResponse_File{}
+	}
+
+	var files []*pluginpb.CodeGeneratorResponse_File
+	for _, file := range res.GetFile() {
+		if file.GetName() == "" && len(files) > 0 {
+			last := files[len(files)-1]
+			last.Content = proto.String(last.GetContent() + file.GetContent())
+
+			continue
+		}
+
+		files = append(files, file)
+	}
+
+	res.File = files[:0]
+	for _, file := range files {
+		point := file.GetInsertionPoint()
+		if point == "" {
+			in.files[file.GetName()] = file
+			res.File = append(res.File, file)
+
+			continue
+		}
+
+		target, ok := in.files[file.GetName()]
+		if !ok {
+			return connect.NewError(
+				connect.CodeInternal,
+				fmt.Errorf("plugin '%s/%s:%s' tried to insert into %q, which was not generated earlier in the request", ref.GetOwner(), ref.GetName(), ref.GetVersion(), file.GetName()),
+			)
+		}
+
+		content, err := insert(target.GetContent(), point, file.GetContent())
+		if err != nil {
+			return connect.NewError(
+				connect.CodeInternal,
+				fmt.Errorf("plugin '%s/%s:%s' tried to insert into %q: %w", ref.GetOwner(), ref.GetName(), ref.GetVersion(), file.GetName(), err),
+			)
+		}
+
+		target.Content = proto.String(content)
+	}
+
+	return nil
+}
+
+// insert inserts "content" into "target" at the insertion point "point",
+// following protoc.
+//
+// Content is inserted before the line containing
+// `@@protoc_insertion_point(<point>)`, so that repeated insertions keep their
+// order, with every line indented as that line is. Insertion points within a
+// `/* ... */` comment have content inserted directly before the comment,
+// without indentation. Content always ends with a newline.
+func insert(target, point, content string) (string, error) {
+	magic := "@@protoc_insertion_point(" + point + ")"
+
+	pos := strings.Index(target, magic)
+	if pos < 0 {
+		return "", fmt.Errorf("file does not contain insertion point %q", point)
+	}
+
+	if pos >= 3 && target[pos-3:pos-1] == "/*" {
+		pos -= 3
+	} else {
+		pos = strings.LastIndexByte(target[:pos], '\n') + 1
+	}
+
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	indent := target[pos:]
+	indent = indent[:len(indent)-len(strings.TrimLeft(indent, " \t"))]
+
+	var b strings.Builder
+	b.WriteString(target[:pos])
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if line == "" {
+			continue
+		}
+
+		b.WriteString(indent)
+		b.WriteString(line)
+	}
+	b.WriteString(target[pos:])
+
+	return b.String(), nil
+}
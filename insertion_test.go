@@ -0,0 +1,216 @@
+package codegenerator
+
+import (
+	"errors"
+	"testing"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+)
+
+func TestInsert(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		point   string
+		content string
+		want    string
+	}{
+		{
+			name:    "before the marker line",
+			target:  "a\n// @@protoc_insertion_point(x)\nb\n",
+			point:   "x",
+			content: "inserted\n",
+			want:    "a\ninserted\n// @@protoc_insertion_point(x)\nb\n",
+		},
+		{
+			name:    "indented as the marker line",
+			target:  "{\n  \t// @@protoc_insertion_point(x)\n}\n",
+			point:   "x",
+			content: "one\ntwo\n",
+			want:    "{\n  \tone\n  \ttwo\n  \t// @@protoc_insertion_point(x)\n}\n",
+		},
+		{
+			name:    "blank lines are indented",
+			target:  "  // @@protoc_insertion_point(x)\n",
+			point:   "x",
+			content: "one\n\ntwo\n",
+			want:    "  one\n  \n  two\n  // @@protoc_insertion_point(x)\n",
+		},
+		{
+			name:    "a newline is added",
+			target:  "  // @@protoc_insertion_point(x)\n",
+			point:   "x",
+			content: "one",
+			want:    "  one\n  // @@protoc_insertion_point(x)\n",
+		},
+		{
+			name:    "empty content",
+			target:  "  // @@protoc_insertion_point(x)\n",
+			point:   "x",
+			content: "",
+			want:    "  // @@protoc_insertion_point(x)\n",
+		},
+		{
+			name:    "marker on the first line",
+			target:  "// @@protoc_insertion_point(x)\nrest\n",
+			point:   "x",
+			content: "first",
+			want:    "first\n// @@protoc_insertion_point(x)\nrest\n",
+		},
+		{
+			name:    "inline comment",
+			target:  "var x = []int{/* @@protoc_insertion_point(x) */}\n",
+			point:   "x",
+			content: "1, 2",
+			want:    "var x = []int{1, 2\n/* @@protoc_insertion_point(x) */}\n",
+		},
+		{
+			name:    "inline comment at the start of the file",
+			target:  "/* @@protoc_insertion_point(x) */\n",
+			point:   "x",
+			content: "first\n",
+			want:    "first\n/* @@protoc_insertion_point(x) */\n",
+		},
+		{
+			name:    "inline comment is not indented",
+			target:  "  f(/* @@protoc_insertion_point(x) */)\n",
+			point:   "x",
+			content: "a\nb\n",
+			want:    "  f(a\nb\n/* @@protoc_insertion_point(x) */)\n",
+		},
+		{
+			name:    "first of several markers",
+			target:  "// @@protoc_insertion_point(x)\n// @@protoc_insertion_point(x)\n",
+			point:   "x",
+			content: "a\n",
+			want:    "a\n// @@protoc_insertion_point(x)\n// @@protoc_insertion_point(x)\n",
+		},
+		{
+			name:    "points are matched exactly",
+			target:  "// @@protoc_insertion_point(xy)\n  // @@protoc_insertion_point(x)\n",
+			point:   "x",
+			content: "a\n",
+			want:    "// @@protoc_insertion_point(xy)\n  a\n  // @@protoc_insertion_point(x)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := insert(tt.target, tt.point, tt.content)
+			if err != nil {
+				t.Fatalf("insert() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("insert() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInsertMultiple(t *testing.T) {
+	target := "class A {\n  // @@protoc_insertion_point(body)\n}\n"
+
+	for _, content := range []string{"first();\n", "second();\nthird();\n"} {
+		var err error
+		target, err = insert(target, "body", content)
+		if err != nil {
+			t.Fatalf("insert() error = %v", err)
+		}
+	}
+
+	want := "class A {\n  first();\n  second();\n  third();\n  // @@protoc_insertion_point(body)\n}\n"
+	if target != want {
+		t.Errorf("insert() = %q, want %q", target, want)
+	}
+}
+
+func TestInsertMissingPoint(t *testing.T) {
+	if _, err := insert("// @@protoc_insertion_point(x)\n", "y", "a"); err == nil {
+		t.Fatal("insert() error = nil, want an error")
+	}
+}
+
+func file(name, point, content string) *pluginpb.CodeGeneratorResponse_File {
+	f := &pluginpb.CodeGeneratorResponse_File{Content: proto.String(content)}
+	if name != "" {
+		f.Name = proto.String(name)
+	}
+	if point != "" {
+		f.InsertionPoint = proto.String(point)
+	}
+
+	return f
+}
+
+func TestInsertionsApply(t *testing.T) {
+	ref := &v1alpha1.CuratedPluginReference{Owner: "acme", Name: "protoc-gen-x", Version: "v1.0.0"}
+
+	first := &pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{
+		file("a.go", "", "package a\n"),
+		file("", "", "\n// @@protoc_insertion_point(imports)\n"),
+		file("a.go", "imports", "import \"fmt\"\n"),
+	}}
+	second := &pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{
+		file("a.go", "imports", "import \"os\""),
+		file("", "", "\nimport \"io\"\n"),
+		file("b.go", "", "package a\n"),
+	}}
+
+	var in insertions
+	for _, res := range []*pluginpb.CodeGeneratorResponse{first, second} {
+		if err := in.apply(ref, res); err != nil {
+			t.Fatalf("apply() error = %v", err)
+		}
+	}
+
+	if len(first.GetFile()) != 1 || len(second.GetFile()) != 1 {
+		t.Fatalf("apply() left %d and %d files, want 1 and 1", len(first.GetFile()), len(second.GetFile()))
+	}
+
+	want := "package a\n\nimport \"fmt\"\nimport \"os\"\nimport \"io\"\n// @@protoc_insertion_point(imports)\n"
+	if got := first.GetFile()[0].GetContent(); got != want {
+		t.Errorf("a.go = %q, want %q", got, want)
+	}
+
+	if got := second.GetFile()[0].GetName(); got != "b.go" {
+		t.Errorf("second file = %q, want b.go", got)
+	}
+}
+
+func TestInsertionsApplyErrors(t *testing.T) {
+	ref := &v1alpha1.CuratedPluginReference{Owner: "acme", Name: "protoc-gen-x", Version: "v1.0.0"}
+
+	tests := []struct {
+		name  string
+		files []*pluginpb.CodeGeneratorResponse_File
+	}{
+		{
+			name:  "unknown file",
+			files: []*pluginpb.CodeGeneratorResponse_File{file("a.go", "x", "a")},
+		},
+		{
+			name: "missing insertion point",
+			files: []*pluginpb.CodeGeneratorResponse_File{
+				file("a.go", "", "// @@protoc_insertion_point(x)\n"),
+				file("a.go", "y", "a"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var in insertions
+			err := in.apply(ref, &pluginpb.CodeGeneratorResponse{File: tt.files})
+
+			var cerr *connect.Error
+			if !errors.As(err, &cerr) || cerr.Code() != connect.CodeInternal {
+				t.Fatalf("apply() error = %v, want internal", err)
+			}
+		})
+	}
+}
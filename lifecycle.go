@@ -0,0 +1,105 @@
+package codegenerator
+
+import (
+	"context"
+	"errors"
+
+	"connectrpc.com/connect"
+)
+
+// begin registers an in-flight GenerateCode call, returning a context which
+// is cancelled if the Service is forced to shut down, and a function to call
+// once the call completes.
+func (s *Service) begin(ctx context.Context) (context.Context, func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.draining {
+		return nil, nil, connect.NewError(connect.CodeUnavailable, errors.New("server is shutting down"))
+	}
+
+	ctx, done := s.enter(ctx)
+
+	return ctx, done, nil
+}
+
+// track registers work done on behalf of in-flight calls, which may outlive
+// them, so that Shutdown waits for it too. It returns a context which is
+// cancelled if the Service is forced to shut down, and a function to call
+// once the work completes.
+func (s *Service) track(ctx context.Context) (context.Context, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.enter(ctx)
+}
+
+// enter counts work as in-flight, it must be called with the lock held.
+func (s *Service) enter(ctx context.Context) (context.Context, func()) {
+	s.init()
+	s.inflight++
+
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(s.abort, cancel)
+
+	return ctx, func() {
+		stop()
+		cancel()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.inflight--
+		if s.inflight == 0 && s.idle != nil {
+			close(s.idle)
+			s.idle = nil
+		}
+	}
+}
+
+func (s *Service) init() {
+	if s.abort == nil {
+		s.abort, s.cancel = context.WithCancel(context.Background())
+	}
+}
+
+// Draining reports whether Shutdown has been called.
+func (s *Service) Draining() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.draining
+}
+
+// Shutdown stops the Service accepting new GenerateCode calls, which fail
+// with `unavailable`, and waits for in-flight calls to complete.
+//
+// If "ctx" is done first, the plugin executions of the remaining calls are
+// cancelled, and Shutdown waits for them to return before returning the
+// context's error.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.draining = true
+	s.init()
+
+	if s.inflight == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+
+	if s.idle == nil {
+		s.idle = make(chan struct{})
+	}
+	idle := s.idle
+	s.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		<-idle
+
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,240 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"connectrpc.com/connect"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+	"github.com/CGA1123/codegenerator/metrics"
+)
+
+// busyRetry is the retry hint given when the execution queue is full, as
+// there is no way of knowing when a slot will be free.
+const busyRetry = time.Second
+
+// Rate is a token bucket, refilled at Rate tokens per second up to Burst.
+type Rate struct {
+	Rate  float64
+	Burst int
+}
+
+// Config configures a Limiter, zero values disable the respective limit.
+type Config struct {
+	// Callers limits the GenerateCode calls of each caller.
+	Callers Rate
+	// Plugins limits how often each plugin may be requested.
+	Plugins Rate
+
+	// Concurrency caps the plugin executions running at once, including
+	// looking the plugin up in its registry.
+	Concurrency int
+	// Queue is how many plugin executions may wait for a slot, beyond which
+	// they are rejected.
+	Queue int
+	// QueueTimeout bounds how long a plugin execution waits for a slot.
+	QueueTimeout time.Duration
+}
+
+// Limiter enforces token bucket rate limits per caller and per plugin, and a
+// global cap on concurrent plugin executions.
+//
+// Requests over a limit are rejected with a Connect `resource_exhausted`
+// error, carrying a RetryInfo detail and `Retry-After` header with how long
+// to wait before retrying.
+type Limiter struct {
+	config Config
+
+	callers *buckets
+	plugins *buckets
+
+	slots   chan struct{}
+	mu      sync.Mutex
+	waiting int
+}
+
+// New builds a Limiter.
+func New(config Config) *Limiter {
+	l := &Limiter{config: config}
+
+	if config.Callers.Rate > 0 {
+		l.callers = newBuckets(config.Callers)
+	}
+
+	if config.Plugins.Rate > 0 {
+		l.plugins = newBuckets(config.Plugins)
+	}
+
+	if config.Concurrency > 0 {
+		l.slots = make(chan struct{}, config.Concurrency)
+	}
+
+	return l
+}
+
+// Allow takes a token from the bucket of "caller", and of each plugin
+// requested.
+//
+// Tokens are only taken if every bucket has one, so a rejected call does not
+// use up the tokens of the buckets checked before it.
+func (l *Limiter) Allow(caller string, refs []*v1alpha1.CuratedPluginReference) error {
+	now := time.Now()
+
+	var reserved []*rate.Reservation
+	reject := func(kind string, err error, delay time.Duration) error {
+		for _, r := range reserved {
+			r.CancelAt(now)
+		}
+
+		metrics.RateLimited.WithLabelValues(kind).Inc()
+
+		return exhausted(err, delay)
+	}
+
+	if l.callers != nil {
+		r, delay := l.callers.take(caller, now)
+		if delay > 0 {
+			return reject("caller", fmt.Errorf("rate limit exceeded for %s", caller), delay)
+		}
+
+		reserved = append(reserved, r)
+	}
+
+	if l.plugins != nil {
+		for _, ref := range refs {
+			name := fmt.Sprintf("%s/%s:%s", ref.GetOwner(), ref.GetName(), ref.GetVersion())
+			r, delay := l.plugins.take(name, now)
+			if delay > 0 {
+				return reject("plugin", fmt.Errorf("rate limit exceeded for plugin '%s'", name), delay)
+			}
+
+			reserved = append(reserved, r)
+		}
+	}
+
+	return nil
+}
+
+// Acquire waits for a plugin execution slot, returning a function which
+// releases it.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	if l.slots == nil {
+		return func() {}, nil
+	}
+
+	release := func() { <-l.slots }
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	l.mu.Lock()
+	if l.waiting >= l.config.Queue {
+		l.mu.Unlock()
+		metrics.RateLimited.WithLabelValues("queue").Inc()
+		return nil, exhausted(errors.New("too many plugin executions queued"), busyRetry)
+	}
+	l.waiting++
+	l.mu.Unlock()
+
+	metrics.PluginsQueued.Inc()
+	defer func() {
+		metrics.PluginsQueued.Dec()
+
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if l.config.QueueTimeout > 0 {
+		timer := time.NewTimer(l.config.QueueTimeout)
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timeout:
+		metrics.RateLimited.WithLabelValues("queue").Inc()
+		return nil, exhausted(fmt.Errorf("no plugin execution slot free after %s", l.config.QueueTimeout), busyRetry)
+	}
+}
+
+func exhausted(err error, retry time.Duration) error {
+	cerr := connect.NewError(connect.CodeResourceExhausted, err)
+
+	if detail, detailErr := connect.NewErrorDetail(&errdetails.RetryInfo{RetryDelay: durationpb.New(retry)}); detailErr == nil {
+		cerr.AddDetail(detail)
+	}
+
+	cerr.Meta().Set("Retry-After", fmt.Sprint(int(math.Ceil(retry.Seconds()))))
+
+	return cerr
+}
+
+// buckets are token buckets by key.
+type buckets struct {
+	rate Rate
+
+	mu      sync.Mutex
+	buckets map[string]*rate.Limiter
+	pruned  time.Time
+}
+
+// pruneInterval is how often full buckets are dropped, a full bucket is
+// indistinguishable from a new one.
+const pruneInterval = time.Minute
+
+func newBuckets(r Rate) *buckets {
+	if r.Burst < 1 {
+		r.Burst = int(math.Max(1, math.Ceil(r.Rate)))
+	}
+
+	return &buckets{rate: r, buckets: map[string]*rate.Limiter{}, pruned: time.Now()}
+}
+
+// take reserves a token from the bucket of "key" at "now", returning the
+// reservation, or how long to wait for a token if it is empty.
+func (b *buckets) take(key string, now time.Time) (*rate.Reservation, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if now.Sub(b.pruned) > pruneInterval {
+		for k, lim := range b.buckets {
+			if lim.TokensAt(now) >= float64(b.rate.Burst) {
+				delete(b.buckets, k)
+			}
+		}
+
+		b.pruned = now
+	}
+
+	lim, ok := b.buckets[key]
+	if !ok {
+		lim = rate.NewLimiter(rate.Limit(b.rate.Rate), b.rate.Burst)
+		b.buckets[key] = lim
+	}
+
+	r := lim.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return nil, delay
+	}
+
+	return r, 0
+}
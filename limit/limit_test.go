@@ -0,0 +1,345 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+)
+
+// slow refills far slower than the tests run.
+const slow = 0.001
+
+func refs(names ...string) []*v1alpha1.CuratedPluginReference {
+	var refs []*v1alpha1.CuratedPluginReference
+	for _, name := range names {
+		refs = append(refs, &v1alpha1.CuratedPluginReference{Owner: "acme", Name: name, Version: "v1.0.0"})
+	}
+
+	return refs
+}
+
+func TestLimiterAllow(t *testing.T) {
+	type call struct {
+		caller  string
+		plugins []string
+		// wantErr is a substring of the error, if the call is rejected.
+		wantErr string
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+		calls  []call
+	}{
+		{
+			name:   "unlimited",
+			config: Config{},
+			calls: []call{
+				{caller: "a", plugins: []string{"x", "x"}},
+				{caller: "a", plugins: []string{"x", "x"}},
+			},
+		},
+		{
+			name:   "caller bucket",
+			config: Config{Callers: Rate{Rate: slow, Burst: 2}},
+			calls: []call{
+				{caller: "a", plugins: []string{"x"}},
+				{caller: "a", plugins: []string{"x"}},
+				{caller: "a", plugins: []string{"x"}, wantErr: "rate limit exceeded for a"},
+				{caller: "b", plugins: []string{"x"}},
+			},
+		},
+		{
+			name:   "caller burst defaults to the rate",
+			config: Config{Callers: Rate{Rate: 1.5}},
+			calls: []call{
+				{caller: "a"},
+				{caller: "a"},
+				{caller: "a", wantErr: "rate limit exceeded for a"},
+			},
+		},
+		{
+			name:   "plugin bucket",
+			config: Config{Plugins: Rate{Rate: slow, Burst: 2}},
+			calls: []call{
+				{caller: "a", plugins: []string{"x"}},
+				{caller: "b", plugins: []string{"x", "y"}},
+				{caller: "c", plugins: []string{"x"}, wantErr: "rate limit exceeded for plugin 'acme/x:v1.0.0'"},
+				{caller: "c", plugins: []string{"y"}},
+			},
+		},
+		{
+			name:   "plugin requested twice",
+			config: Config{Plugins: Rate{Rate: slow, Burst: 1}},
+			calls: []call{
+				{caller: "a", plugins: []string{"x", "x"}, wantErr: "rate limit exceeded for plugin 'acme/x:v1.0.0'"},
+				{caller: "a", plugins: []string{"x"}},
+			},
+		},
+		{
+			name:   "caller refunded when a plugin is rejected",
+			config: Config{Callers: Rate{Rate: slow, Burst: 1}, Plugins: Rate{Rate: slow, Burst: 1}},
+			calls: []call{
+				{caller: "a", plugins: []string{"x"}},
+				{caller: "b", plugins: []string{"x"}, wantErr: "rate limit exceeded for plugin"},
+				{caller: "b", plugins: []string{"y"}},
+			},
+		},
+		{
+			name:   "plugins refunded when a later plugin is rejected",
+			config: Config{Plugins: Rate{Rate: slow, Burst: 1}},
+			calls: []call{
+				{caller: "a", plugins: []string{"z"}},
+				{caller: "a", plugins: []string{"x", "y", "z"}, wantErr: "rate limit exceeded for plugin 'acme/z:v1.0.0'"},
+				{caller: "a", plugins: []string{"x", "y"}},
+			},
+		},
+		{
+			name:   "plugins untouched when the caller is rejected",
+			config: Config{Callers: Rate{Rate: slow, Burst: 1}, Plugins: Rate{Rate: slow, Burst: 1}},
+			calls: []call{
+				{caller: "a"},
+				{caller: "a", plugins: []string{"x"}, wantErr: "rate limit exceeded for a"},
+				{caller: "b", plugins: []string{"x"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.config)
+
+			for i, c := range tt.calls {
+				err := l.Allow(c.caller, refs(c.plugins...))
+				if c.wantErr == "" {
+					if err != nil {
+						t.Fatalf("calls[%d]: Allow() error = %v", i, err)
+					}
+
+					continue
+				}
+
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("calls[%d]: Allow() error = %v, want %q", i, err, c.wantErr)
+				}
+
+				if code := connect.CodeOf(err); code != connect.CodeResourceExhausted {
+					t.Fatalf("calls[%d]: Allow() code = %v, want resource_exhausted", i, code)
+				}
+			}
+		})
+	}
+}
+
+// retry returns the RetryInfo delay and Retry-After header of "err".
+func retry(t *testing.T, err error) (time.Duration, string) {
+	t.Helper()
+
+	var cerr *connect.Error
+	if !errors.As(err, &cerr) || cerr.Code() != connect.CodeResourceExhausted {
+		t.Fatalf("error = %v, want resource_exhausted", err)
+	}
+
+	var delay time.Duration
+	for _, detail := range cerr.Details() {
+		msg, err := detail.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if info, ok := msg.(*errdetails.RetryInfo); ok {
+			delay = info.GetRetryDelay().AsDuration()
+		}
+	}
+
+	if delay == 0 {
+		t.Fatalf("error = %v, has no RetryInfo", err)
+	}
+
+	return delay, cerr.Meta().Get("Retry-After")
+}
+
+func TestLimiterRetryInfo(t *testing.T) {
+	l := New(Config{Callers: Rate{Rate: 0.5, Burst: 1}})
+
+	if err := l.Allow("a", nil); err != nil {
+		t.Fatalf("Allow() error = %v", err)
+	}
+
+	delay, after := retry(t, l.Allow("a", nil))
+
+	// A token is refilled every 2s.
+	if delay <= time.Second || delay > 2*time.Second {
+		t.Errorf("RetryInfo delay = %v, want up to 2s", delay)
+	}
+
+	if after != "2" {
+		t.Errorf("Retry-After = %q, want 2", after)
+	}
+}
+
+func TestLimiterAcquire(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		// held is how many slots are taken before the call under test.
+		held int
+		// queued is how many executions are waiting before the call
+		// under test.
+		queued int
+		// cancel cancels the context of the call under test.
+		cancel  bool
+		wantErr string
+	}{
+		{
+			name:   "unlimited",
+			config: Config{},
+			held:   10,
+		},
+		{
+			name:   "free slot",
+			config: Config{Concurrency: 2},
+			held:   1,
+		},
+		{
+			name:    "no queue",
+			config:  Config{Concurrency: 1},
+			held:    1,
+			wantErr: "too many plugin executions queued",
+		},
+		{
+			name:    "queue full",
+			config:  Config{Concurrency: 1, Queue: 1},
+			held:    1,
+			queued:  1,
+			wantErr: "too many plugin executions queued",
+		},
+		{
+			name:    "queue timeout",
+			config:  Config{Concurrency: 1, Queue: 1, QueueTimeout: 10 * time.Millisecond},
+			held:    1,
+			wantErr: "no plugin execution slot free after 10ms",
+		},
+		{
+			name:    "cancelled while queued",
+			config:  Config{Concurrency: 1, Queue: 1},
+			held:    1,
+			cancel:  true,
+			wantErr: context.Canceled.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New(tt.config)
+
+			for range tt.held {
+				if _, err := l.Acquire(context.Background()); err != nil {
+					t.Fatalf("Acquire() error = %v", err)
+				}
+			}
+
+			queueCtx, cancelQueued := context.WithCancel(context.Background())
+			defer cancelQueued()
+
+			for range tt.queued {
+				go func() { _, _ = l.Acquire(queueCtx) }()
+			}
+
+			waitQueued(t, l, tt.queued)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if tt.cancel {
+				time.AfterFunc(10*time.Millisecond, cancel)
+			}
+
+			release, err := l.Acquire(ctx)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Acquire() error = %v", err)
+				}
+
+				release()
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Acquire() error = %v, want %q", err, tt.wantErr)
+			}
+
+			if tt.cancel {
+				return
+			}
+
+			delay, after := retry(t, err)
+			if delay != busyRetry || after != "1" {
+				t.Errorf("retry = %v, %q, want %v, 1", delay, after, busyRetry)
+			}
+		})
+	}
+}
+
+func TestLimiterAcquireQueued(t *testing.T) {
+	l := New(Config{Concurrency: 1, Queue: 1})
+
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		release, err := l.Acquire(context.Background())
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+
+	waitQueued(t, l, 1)
+
+	// Releasing the slot hands it to the queued execution.
+	release()
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("queued Acquire() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued execution did not get the released slot")
+	}
+
+	waitQueued(t, l, 0)
+}
+
+// waitQueued waits until "n" executions are queued for a slot.
+func waitQueued(t *testing.T, l *Limiter, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		waiting := l.waiting
+		l.mu.Unlock()
+
+		if waiting == n {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("%d executions queued, want %d", waiting, n)
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
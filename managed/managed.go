@@ -0,0 +1,239 @@
+package managed
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Config rewrites the file options of descriptors before they are given to
+// plugins, like buf's managed mode.
+//
+// Overrides set a file option of the files they match, the last matching
+// override of an option wins. Disables stop an option, or all options, from
+// being rewritten for the files they match. Well-known types are never
+// rewritten.
+type Config struct {
+	Disable  []Disable
+	Override []Override
+}
+
+// Disable stops the rewriting of FileOption, or all options if empty, for
+// files matching Module and Path.
+type Disable struct {
+	// Module is the name of the module files must be from, e.g.
+	// `buf.build/acme/payments`, any module if empty.
+	Module string
+	// Path is the file, or directory of files, to match, any file if empty.
+	Path string
+	// FileOption is the option not to rewrite, e.g. `go_package`.
+	FileOption string
+}
+
+// Override sets FileOption to Value for files matching Module and Path.
+type Override struct {
+	Module string
+	Path   string
+	// FileOption is the option to set, e.g. `java_package`, or a prefix
+	// option, `go_package_prefix` or `java_package_prefix`, from which the
+	// option is derived for each file.
+	FileOption string
+	Value      string
+}
+
+// fileOption is an option which can be overridden.
+type fileOption struct {
+	// option is the name of the file option set.
+	option string
+	parse  func(value string) error
+	set    func(options *descriptorpb.FileOptions, file *descriptorpb.FileDescriptorProto, value string)
+}
+
+var fileOptions = map[string]fileOption{
+	"go_package": stringOption("go_package", func(o *descriptorpb.FileOptions, v *string) { o.GoPackage = v }),
+	"go_package_prefix": {
+		option: "go_package",
+		set: func(o *descriptorpb.FileOptions, file *descriptorpb.FileDescriptorProto, value string) {
+			o.GoPackage = proto.String(goPackage(value, file))
+		},
+	},
+	"java_package": stringOption("java_package", func(o *descriptorpb.FileOptions, v *string) { o.JavaPackage = v }),
+	"java_package_prefix": {
+		option: "java_package",
+		set: func(o *descriptorpb.FileOptions, file *descriptorpb.FileDescriptorProto, value string) {
+			if file.GetPackage() != "" {
+				value += "." + file.GetPackage()
+			}
+
+			o.JavaPackage = proto.String(value)
+		},
+	},
+	"java_outer_classname":   stringOption("java_outer_classname", func(o *descriptorpb.FileOptions, v *string) { o.JavaOuterClassname = v }),
+	"java_multiple_files":    boolOption("java_multiple_files", func(o *descriptorpb.FileOptions, v *bool) { o.JavaMultipleFiles = v }),
+	"java_string_check_utf8": boolOption("java_string_check_utf8", func(o *descriptorpb.FileOptions, v *bool) { o.JavaStringCheckUtf8 = v }),
+	"cc_enable_arenas":       boolOption("cc_enable_arenas", func(o *descriptorpb.FileOptions, v *bool) { o.CcEnableArenas = v }),
+	"objc_class_prefix":      stringOption("objc_class_prefix", func(o *descriptorpb.FileOptions, v *string) { o.ObjcClassPrefix = v }),
+	"csharp_namespace":       stringOption("csharp_namespace", func(o *descriptorpb.FileOptions, v *string) { o.CsharpNamespace = v }),
+	"swift_prefix":           stringOption("swift_prefix", func(o *descriptorpb.FileOptions, v *string) { o.SwiftPrefix = v }),
+	"php_namespace":          stringOption("php_namespace", func(o *descriptorpb.FileOptions, v *string) { o.PhpNamespace = v }),
+	"php_metadata_namespace": stringOption("php_metadata_namespace", func(o *descriptorpb.FileOptions, v *string) { o.PhpMetadataNamespace = v }),
+	"ruby_package":           stringOption("ruby_package", func(o *descriptorpb.FileOptions, v *string) { o.RubyPackage = v }),
+	"optimize_for": {
+		option: "optimize_for",
+		parse: func(value string) error {
+			if _, ok := descriptorpb.FileOptions_OptimizeMode_value[value]; !ok {
+				return errors.New("must be one of SPEED, CODE_SIZE or LITE_RUNTIME")
+			}
+
+			return nil
+		},
+		set: func(o *descriptorpb.FileOptions, _ *descriptorpb.FileDescriptorProto, value string) {
+			o.OptimizeFor = descriptorpb.FileOptions_OptimizeMode(descriptorpb.FileOptions_OptimizeMode_value[value]).Enum()
+		},
+	},
+}
+
+// packageVersion matches the last component of versioned packages, e.g. `v1`
+// or `v1beta1`, as buf does.
+var packageVersion = regexp.MustCompile(`^v[1-9][0-9]*((p[1-9][0-9]*)?(alpha|beta)([1-9][0-9]*)?|test[a-z0-9]*)?$`)
+
+// goPackage is the go_package of "file" under "prefix", named after the last
+// two components of its package if it is versioned, e.g.
+// `<prefix>/acme/pet/v1;petv1` for `acme.pet.v1`, like buf's managed mode.
+func goPackage(prefix string, file *descriptorpb.FileDescriptorProto) string {
+	importPath := path.Join(prefix, path.Dir(file.GetName()))
+
+	parts := strings.Split(file.GetPackage(), ".")
+	if len(parts) >= 2 && packageVersion.MatchString(parts[len(parts)-1]) {
+		importPath += ";" + parts[len(parts)-2] + parts[len(parts)-1]
+	}
+
+	return importPath
+}
+
+func stringOption(name string, field func(*descriptorpb.FileOptions, *string)) fileOption {
+	return fileOption{
+		option: name,
+		set: func(o *descriptorpb.FileOptions, _ *descriptorpb.FileDescriptorProto, value string) {
+			field(o, proto.String(value))
+		},
+	}
+}
+
+func boolOption(name string, field func(*descriptorpb.FileOptions, *bool)) fileOption {
+	return fileOption{
+		option: name,
+		parse: func(value string) error {
+			if _, err := strconv.ParseBool(value); err != nil {
+				return errors.New("must be true or false")
+			}
+
+			return nil
+		},
+		set: func(o *descriptorpb.FileOptions, _ *descriptorpb.FileDescriptorProto, value string) {
+			b, _ := strconv.ParseBool(value)
+			field(o, proto.Bool(b))
+		},
+	}
+}
+
+// CheckOverride returns an error if "option" can't be overridden with
+// "value".
+func CheckOverride(option, value string) error {
+	opt, ok := fileOptions[option]
+	if !ok {
+		return fmt.Errorf("unsupported file option %q", option)
+	}
+
+	if opt.parse != nil {
+		if err := opt.parse(value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %w", value, option, err)
+		}
+	}
+
+	return nil
+}
+
+// CheckDisable returns an error if "option" can't be disabled.
+func CheckDisable(option string) error {
+	if option == "" {
+		return nil
+	}
+
+	for _, opt := range fileOptions {
+		if opt.option == option {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported file option %q", option)
+}
+
+// Rewrite applies the overrides matching "file", from "module", to its
+// options. The options are copied before being changed, as they may be
+// shared with the image the descriptor was built from.
+func (c *Config) Rewrite(module string, file *descriptorpb.FileDescriptorProto) {
+	if c == nil || strings.HasPrefix(file.GetName(), "google/protobuf/") {
+		return
+	}
+
+	overrides := map[string]Override{}
+	var order []string
+	for _, o := range c.Override {
+		opt, ok := fileOptions[o.FileOption]
+		if !ok || !matches(o.Module, o.Path, module, file.GetName()) || c.disabled(opt.option, module, file.GetName()) {
+			continue
+		}
+
+		if _, ok := overrides[opt.option]; !ok {
+			order = append(order, opt.option)
+		}
+		overrides[opt.option] = o
+	}
+
+	if len(overrides) == 0 {
+		return
+	}
+
+	options := &descriptorpb.FileOptions{}
+	if file.GetOptions() != nil {
+		options = proto.Clone(file.GetOptions()).(*descriptorpb.FileOptions)
+	}
+
+	for _, option := range order {
+		o := overrides[option]
+		fileOptions[o.FileOption].set(options, file, o.Value)
+	}
+
+	file.Options = options
+}
+
+func (c *Config) disabled(option, module, file string) bool {
+	for _, d := range c.Disable {
+		if (d.FileOption == "" || d.FileOption == option) && matches(d.Module, d.Path, module, file) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matches(wantModule, wantPath, module, file string) bool {
+	if wantModule != "" && wantModule != module {
+		return false
+	}
+
+	if wantPath == "" {
+		return true
+	}
+
+	wantPath = path.Clean(wantPath)
+
+	return file == wantPath || wantPath == "." || strings.HasPrefix(file, wantPath+"/")
+}
@@ -0,0 +1,240 @@
+package managed
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+func file(name, pkg string) *descriptorpb.FileDescriptorProto {
+	return &descriptorpb.FileDescriptorProto{
+		Name:    proto.String(name),
+		Package: proto.String(pkg),
+		Options: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/original")},
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	const module = "buf.build/acme/pet"
+
+	tests := []struct {
+		name   string
+		config Config
+		module string
+		file   *descriptorpb.FileDescriptorProto
+		// want are the options the file should have.
+		want *descriptorpb.FileOptions
+	}{
+		{
+			name:   "no overrides",
+			config: Config{},
+			file:   file("acme/pet/v1/pet.proto", "acme.pet.v1"),
+			want:   &descriptorpb.FileOptions{GoPackage: proto.String("example.com/original")},
+		},
+		{
+			name: "override",
+			config: Config{Override: []Override{
+				{FileOption: "java_package", Value: "com.acme"},
+				{FileOption: "java_multiple_files", Value: "true"},
+				{FileOption: "optimize_for", Value: "CODE_SIZE"},
+			}},
+			file: file("acme/pet/v1/pet.proto", "acme.pet.v1"),
+			want: &descriptorpb.FileOptions{
+				GoPackage:         proto.String("example.com/original"),
+				JavaPackage:       proto.String("com.acme"),
+				JavaMultipleFiles: proto.Bool(true),
+				OptimizeFor:       descriptorpb.FileOptions_CODE_SIZE.Enum(),
+			},
+		},
+		{
+			name: "last matching override wins",
+			config: Config{Override: []Override{
+				{FileOption: "go_package", Value: "example.com/first"},
+				{FileOption: "go_package", Value: "example.com/last"},
+				{FileOption: "go_package", Path: "other", Value: "example.com/other"},
+			}},
+			file: file("acme/pet/v1/pet.proto", "acme.pet.v1"),
+			want: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/last")},
+		},
+		{
+			name: "prefix and option override each other",
+			config: Config{Override: []Override{
+				{FileOption: "go_package", Value: "example.com/exact"},
+				{FileOption: "go_package_prefix", Value: "example.com/gen"},
+			}},
+			file: file("acme/pet/v1/pet.proto", "acme.pet.v1"),
+			want: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/gen/acme/pet/v1;petv1")},
+		},
+		{
+			name: "override by module and path",
+			config: Config{Override: []Override{
+				{FileOption: "go_package", Value: "example.com/all"},
+				{FileOption: "go_package", Module: module, Path: "acme/pet", Value: "example.com/pet"},
+				{FileOption: "go_package", Module: "buf.build/acme/other", Value: "example.com/other"},
+				{FileOption: "go_package", Path: "acme/pe", Value: "example.com/partial"},
+			}},
+			module: module,
+			file:   file("acme/pet/v1/pet.proto", "acme.pet.v1"),
+			want:   &descriptorpb.FileOptions{GoPackage: proto.String("example.com/pet")},
+		},
+		{
+			name: "disabled by module",
+			config: Config{
+				Disable:  []Disable{{Module: module}},
+				Override: []Override{{FileOption: "go_package", Value: "example.com/gen"}},
+			},
+			module: module,
+			file:   file("acme/pet/v1/pet.proto", "acme.pet.v1"),
+			want:   &descriptorpb.FileOptions{GoPackage: proto.String("example.com/original")},
+		},
+		{
+			name: "disabled for another module",
+			config: Config{
+				Disable:  []Disable{{Module: "buf.build/acme/other"}},
+				Override: []Override{{FileOption: "go_package", Value: "example.com/gen"}},
+			},
+			module: module,
+			file:   file("acme/pet/v1/pet.proto", "acme.pet.v1"),
+			want:   &descriptorpb.FileOptions{GoPackage: proto.String("example.com/gen")},
+		},
+		{
+			name: "disabled by path",
+			config: Config{
+				Disable:  []Disable{{Path: "acme/pet/v1/pet.proto"}},
+				Override: []Override{{FileOption: "go_package", Value: "example.com/gen"}},
+			},
+			file: file("acme/pet/v1/pet.proto", "acme.pet.v1"),
+			want: &descriptorpb.FileOptions{GoPackage: proto.String("example.com/original")},
+		},
+		{
+			name: "disabled by option",
+			config: Config{
+				Disable: []Disable{{FileOption: "go_package"}},
+				Override: []Override{
+					{FileOption: "go_package_prefix", Value: "example.com/gen"},
+					{FileOption: "java_package", Value: "com.acme"},
+				},
+			},
+			file: file("acme/pet/v1/pet.proto", "acme.pet.v1"),
+			want: &descriptorpb.FileOptions{
+				GoPackage:   proto.String("example.com/original"),
+				JavaPackage: proto.String("com.acme"),
+			},
+		},
+		{
+			name:   "well-known types",
+			config: Config{Override: []Override{{FileOption: "go_package", Value: "example.com/gen"}}},
+			file:   file("google/protobuf/timestamp.proto", "google.protobuf"),
+			want:   &descriptorpb.FileOptions{GoPackage: proto.String("example.com/original")},
+		},
+		{
+			name:   "file without options",
+			config: Config{Override: []Override{{FileOption: "go_package", Value: "example.com/gen"}}},
+			file:   &descriptorpb.FileDescriptorProto{Name: proto.String("pet.proto")},
+			want:   &descriptorpb.FileOptions{GoPackage: proto.String("example.com/gen")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Rewrite(tt.module, tt.file)
+
+			if got := tt.file.GetOptions(); !proto.Equal(got, tt.want) {
+				t.Fatalf("Rewrite() options = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewritePrefixes(t *testing.T) {
+	tests := []struct {
+		option string
+		file   *descriptorpb.FileDescriptorProto
+		want   string
+	}{
+		{"go_package_prefix", file("acme/pet/v1/pet.proto", "acme.pet.v1"), "example.com/gen/acme/pet/v1;petv1"},
+		{"go_package_prefix", file("acme/pet/v1beta1/pet.proto", "acme.pet.v1beta1"), "example.com/gen/acme/pet/v1beta1;petv1beta1"},
+		{"go_package_prefix", file("acme/pet/v1p1alpha2/pet.proto", "acme.pet.v1p1alpha2"), "example.com/gen/acme/pet/v1p1alpha2;petv1p1alpha2"},
+		{"go_package_prefix", file("acme/pet/v2test/pet.proto", "acme.pet.v2test"), "example.com/gen/acme/pet/v2test;petv2test"},
+		{"go_package_prefix", file("acme/pet/pet.proto", "acme.pet"), "example.com/gen/acme/pet"},
+		{"go_package_prefix", file("acme/pet/v0/pet.proto", "acme.pet.v0"), "example.com/gen/acme/pet/v0"},
+		{"go_package_prefix", file("v1/pet.proto", "v1"), "example.com/gen/v1"},
+		{"go_package_prefix", file("pet.proto", ""), "example.com/gen"},
+		{"java_package_prefix", file("acme/pet/v1/pet.proto", "acme.pet.v1"), "com.example.acme.pet.v1"},
+		{"java_package_prefix", file("pet.proto", ""), "com.example"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.option+"/"+tt.file.GetName(), func(t *testing.T) {
+			value := "example.com/gen"
+			if tt.option == "java_package_prefix" {
+				value = "com.example"
+			}
+
+			c := &Config{Override: []Override{{FileOption: tt.option, Value: value}}}
+			c.Rewrite("", tt.file)
+
+			got := tt.file.GetOptions().GetGoPackage()
+			if tt.option == "java_package_prefix" {
+				got = tt.file.GetOptions().GetJavaPackage()
+			}
+
+			if got != tt.want {
+				t.Fatalf("Rewrite() %s = %q, want %q", tt.option, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteCopiesOptions(t *testing.T) {
+	source := file("acme/pet/v1/pet.proto", "acme.pet.v1")
+
+	// Descriptors given to plugins share their options with the image they
+	// were built from.
+	descriptor := proto.Clone(source).(*descriptorpb.FileDescriptorProto)
+	descriptor.Options = source.GetOptions()
+
+	c := &Config{Override: []Override{{FileOption: "go_package_prefix", Value: "example.com/gen"}}}
+	c.Rewrite("", descriptor)
+
+	if got := descriptor.GetOptions().GetGoPackage(); got != "example.com/gen/acme/pet/v1;petv1" {
+		t.Fatalf("Rewrite() go_package = %q", got)
+	}
+
+	if got := source.GetOptions().GetGoPackage(); got != "example.com/original" {
+		t.Fatalf("source go_package = %q, want it unchanged", got)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	for _, tt := range []struct {
+		option, value string
+		ok            bool
+	}{
+		{"go_package_prefix", "example.com/gen", true},
+		{"java_multiple_files", "true", true},
+		{"java_multiple_files", "yes", false},
+		{"optimize_for", "LITE_RUNTIME", true},
+		{"optimize_for", "FAST", false},
+		{"unknown", "", false},
+	} {
+		if err := CheckOverride(tt.option, tt.value); (err == nil) != tt.ok {
+			t.Errorf("CheckOverride(%q, %q) error = %v, want ok %v", tt.option, tt.value, err, tt.ok)
+		}
+	}
+
+	for _, tt := range []struct {
+		option string
+		ok     bool
+	}{
+		{"", true},
+		{"go_package", true},
+		{"go_package_prefix", false},
+		{"unknown", false},
+	} {
+		if err := CheckDisable(tt.option); (err == nil) != tt.ok {
+			t.Errorf("CheckDisable(%q) error = %v, want ok %v", tt.option, err, tt.ok)
+		}
+	}
+}
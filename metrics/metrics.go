@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "codegenerator"
+
+// pluginLabels identify the plugin a metric is about.
+var pluginLabels = []string{"owner", "plugin", "version"}
+
+// sizeBuckets range from 1KiB to 256MiB.
+var sizeBuckets = prometheus.ExponentialBuckets(1024, 4, 10)
+
+var (
+	// Requests counts GenerateCode requests by their Connect code, `ok` for
+	// successful requests.
+	Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "generate_requests_total",
+		Help:      "GenerateCode requests by result code.",
+	}, []string{"code"})
+
+	// RequestDuration observes how long GenerateCode requests take.
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "generate_request_duration_seconds",
+		Help:      "Duration of GenerateCode requests by result code.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"code"})
+
+	// RequestBytes observes the size of GenerateCode requests.
+	RequestBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "generate_request_bytes",
+		Help:      "Size of GenerateCode requests.",
+		Buckets:   sizeBuckets,
+	})
+
+	// ResponseBytes observes the size of successful GenerateCode responses.
+	ResponseBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "generate_response_bytes",
+		Help:      "Size of GenerateCode responses.",
+		Buckets:   sizeBuckets,
+	})
+
+	// PluginExecutions counts plugin executions by status, one of `ok`,
+	// `failed` (the plugin reported an error), `timeout`, `canceled` or
+	// `error`.
+	PluginExecutions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "plugin_executions_total",
+		Help:      "Plugin executions by plugin and status.",
+	}, append(pluginLabels, "status"))
+
+	// PluginDuration observes how long plugin executions take.
+	PluginDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "plugin_execution_duration_seconds",
+		Help:      "Duration of plugin executions by plugin.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, pluginLabels)
+
+	// PluginsInFlight is the number of plugin executions in progress.
+	PluginsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "plugin_executions_in_flight",
+		Help:      "Plugin executions in progress by plugin.",
+	}, pluginLabels)
+
+	// PluginExits counts how plugin processes exited, by exit code or, if
+	// killed, by signal.
+	PluginExits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "plugin_process_exits_total",
+		Help:      "Plugin process exits by plugin and exit status.",
+	}, append(pluginLabels, "exit_status"))
+
+	// PluginsQueued is the number of plugin executions waiting for a slot.
+	PluginsQueued = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "plugin_executions_queued",
+		Help:      "Plugin executions waiting for a concurrency slot.",
+	})
+
+	// RateLimited counts requests rejected by a limit, one of `caller`,
+	// `plugin` or `queue`.
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by rate limits and concurrency quotas, by limit.",
+	}, []string{"limit"})
+
+	// CacheRequests counts lookups of plugin caches, by cache and whether
+	// the lookup was a `hit` or a `miss`.
+	CacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Plugin cache lookups by cache and result.",
+	}, []string{"cache", "result"})
+)
+
+// CacheResult returns the result label for a cache lookup.
+func CacheResult(hit bool) string {
+	if hit {
+		return "hit"
+	}
+
+	return "miss"
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
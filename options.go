@@ -0,0 +1,121 @@
+package codegenerator
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"connectrpc.com/connect"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+)
+
+// parameter builds the parameter given to the plugin "ref" from the options
+// requested by the caller.
+//
+// Requested options matching a denied pattern are rejected. Requested options
+// with the same name as a forced option are dropped, and defaults are added
+// for any option not otherwise set. Options are named by the part before any
+// `=`, e.g. `paths` for `paths=source_relative`.
+func (c PluginConfig) parameter(ref *v1alpha1.CuratedPluginReference, requested []string) (string, error) {
+	if len(c.DefaultOptions) == 0 && len(c.Options) == 0 && len(c.ForcedOptions) == 0 && len(c.DeniedOptions) == 0 {
+		return strings.Join(requested, ","), nil
+	}
+
+	var options []string
+	for _, option := range requested {
+		options = append(options, strings.FieldsFunc(option, func(r rune) bool { return r == ',' })...)
+	}
+
+	for _, option := range options {
+		if c.denied(option) {
+			return "", connect.NewError(
+				connect.CodePermissionDenied,
+				fmt.Errorf("option %q is not allowed for plugin '%s/%s:%s'", option, ref.GetOwner(), ref.GetName(), ref.GetVersion()),
+			)
+		}
+	}
+
+	forced := optionNames(c.ForcedOptions)
+	options = slices.DeleteFunc(options, func(option string) bool {
+		return forced[optionName(option)]
+	})
+
+	set := optionNames(options)
+	for _, option := range c.DefaultOptions {
+		if name := optionName(option); !set[name] && !forced[name] {
+			options = append(options, option)
+		}
+	}
+
+	return strings.Join(slices.Concat(options, c.Options, c.ForcedOptions), ","), nil
+}
+
+// Compile compiles the DeniedOptions patterns, so they are not compiled again
+// for every request. It must be called again if DeniedOptions changes.
+func (c *PluginConfig) Compile() {
+	c.deniedOptions = compileOptionPatterns(c.DeniedOptions)
+}
+
+// denied reports whether "option" matches any of the denied patterns, either
+// in full or by name.
+func (c PluginConfig) denied(option string) bool {
+	patterns := c.deniedOptions
+	if len(patterns) != len(c.DeniedOptions) {
+		patterns = compileOptionPatterns(c.DeniedOptions)
+	}
+
+	for _, pattern := range patterns {
+		if pattern.MatchString(option) || pattern.MatchString(optionName(option)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func compileOptionPatterns(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		compiled[i] = compileOptionPattern(pattern)
+	}
+
+	return compiled
+}
+
+// compileOptionPattern compiles "pattern", where `*` matches any sequence of
+// characters and `?` any single character. Unlike path.Match, `*` matches
+// `/`, as option values are often paths.
+func compileOptionPattern(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+
+	return regexp.MustCompile(expr.String())
+}
+
+func optionName(option string) string {
+	name, _, _ := strings.Cut(option, "=")
+
+	return name
+}
+
+func optionNames(options []string) map[string]bool {
+	names := make(map[string]bool, len(options))
+	for _, option := range options {
+		names[optionName(option)] = true
+	}
+
+	return names
+}
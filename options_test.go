@@ -0,0 +1,149 @@
+package codegenerator
+
+import (
+	"errors"
+	"testing"
+
+	"connectrpc.com/connect"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+)
+
+func TestPluginConfigParameter(t *testing.T) {
+	ref := &v1alpha1.CuratedPluginReference{Owner: "acme", Name: "protoc-gen-go", Version: "v1.0.0"}
+
+	tests := []struct {
+		name      string
+		config    PluginConfig
+		requested []string
+		want      string
+		denied    bool
+	}{
+		{
+			name:      "no configuration",
+			requested: []string{"a=1", "b"},
+			want:      "a=1,b",
+		},
+		{
+			name:      "defaults are added when absent",
+			config:    PluginConfig{DefaultOptions: []string{"paths=import", "lang=go"}},
+			requested: []string{"lang=ts"},
+			want:      "lang=ts,paths=import",
+		},
+		{
+			name:      "forced options replace requested ones",
+			config:    PluginConfig{ForcedOptions: []string{"paths=source_relative"}},
+			requested: []string{"paths=import,a=1"},
+			want:      "a=1,paths=source_relative",
+		},
+		{
+			name:      "forced options win over defaults",
+			config:    PluginConfig{DefaultOptions: []string{"paths=import"}, ForcedOptions: []string{"paths=source_relative"}},
+			requested: nil,
+			want:      "paths=source_relative",
+		},
+		{
+			name:      "appended options follow requested ones",
+			config:    PluginConfig{Options: []string{"x=1"}},
+			requested: []string{"a"},
+			want:      "a,x=1",
+		},
+		{
+			name:      "denied by name",
+			config:    PluginConfig{DeniedOptions: []string{"out"}},
+			requested: []string{"out=gen"},
+			denied:    true,
+		},
+		{
+			name:      "denied by name within a combined option",
+			config:    PluginConfig{DeniedOptions: []string{"plugins"}},
+			requested: []string{"paths=import,plugins=grpc"},
+			denied:    true,
+		},
+		{
+			name:      "wildcard value matches a traversal",
+			config:    PluginConfig{DeniedOptions: []string{"out=*"}},
+			requested: []string{"out=../../etc/x"},
+			denied:    true,
+		},
+		{
+			name:      "traversal anywhere in a value",
+			config:    PluginConfig{DeniedOptions: []string{"*=*..*"}},
+			requested: []string{"module=github.com/acme/../../x"},
+			denied:    true,
+		},
+		{
+			name:      "leading traversal",
+			config:    PluginConfig{DeniedOptions: []string{"*=../*"}},
+			requested: []string{"out=../../etc/x"},
+			denied:    true,
+		},
+		{
+			name:      "absolute path values",
+			config:    PluginConfig{DeniedOptions: []string{"*=/*"}},
+			requested: []string{"out=/etc/passwd"},
+			denied:    true,
+		},
+		{
+			name:      "single character wildcard",
+			config:    PluginConfig{DeniedOptions: []string{"M?"}},
+			requested: []string{"Mx=y"},
+			denied:    true,
+		},
+		{
+			name:      "patterns match in full",
+			config:    PluginConfig{DeniedOptions: []string{"out", "*=../*"}},
+			requested: []string{"output=gen/../x", "paths=source_relative"},
+			want:      "output=gen/../x,paths=source_relative",
+		},
+		{
+			name:      "pattern metacharacters are literal",
+			config:    PluginConfig{DeniedOptions: []string{"a.b", "[x]"}},
+			requested: []string{"aXb", "x"},
+			want:      "aXb,x",
+		},
+	}
+
+	for _, tt := range tests {
+		// Configurations behave the same whether or not their patterns
+		// were compiled up front.
+		compiled := tt.config
+		compiled.Compile()
+
+		for name, config := range map[string]PluginConfig{"compiled": compiled, "uncompiled": tt.config} {
+			t.Run(tt.name+"/"+name, func(t *testing.T) {
+				got, err := config.parameter(ref, tt.requested)
+				if tt.denied {
+					var cerr *connect.Error
+					if !errors.As(err, &cerr) || cerr.Code() != connect.CodePermissionDenied {
+						t.Fatalf("parameter() error = %v, want permission_denied", err)
+					}
+
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("parameter() error = %v", err)
+				}
+
+				if got != tt.want {
+					t.Errorf("parameter() = %q, want %q", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkPluginConfigParameter(b *testing.B) {
+	ref := &v1alpha1.CuratedPluginReference{Owner: "acme", Name: "protoc-gen-go", Version: "v1.0.0"}
+	config := PluginConfig{DeniedOptions: []string{"out", "plugins", "*=../*", "*=/*", "*=*..*"}}
+	config.Compile()
+	requested := []string{"paths=source_relative,module=github.com/acme/gen,Mfoo.proto=github.com/acme/foo"}
+
+	b.ReportAllocs()
+	for range b.N {
+		if _, err := config.parameter(ref, requested); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
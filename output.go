@@ -0,0 +1,137 @@
+package codegenerator
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+)
+
+// Output configures how the files generated by a plugin are post-processed.
+type Output struct {
+	// StripPrefix is a directory removed from the start of file names.
+	StripPrefix string
+	// Prefix is a directory prepended to file names, after StripPrefix.
+	Prefix string
+	// Exclude are path.Match patterns of files to drop, matched against
+	// the name generated by the plugin, or its base name for patterns
+	// without a `/`.
+	Exclude []string
+}
+
+// outputs tracks the files generated by the plugins of a request.
+type outputs struct {
+	maxBytes int
+	// insertions requires insertion points to be for files generated
+	// earlier in the request, as they are applied by the server. Otherwise
+	// the client applies them, and may have generated the file itself, e.g.
+	// with a local plugin, so the file can't be checked.
+	insertions bool
+
+	bytes int
+	files map[string]bool
+}
+
+// process validates and rewrites the files generated by the plugin "ref",
+// according to "output".
+//
+// File names must be relative and may not contain `..`. If insertions is
+// set, files with an insertion point must name a file generated earlier in
+// the request, after its name is rewritten, otherwise their target is left
+// for the client to check. The total size of the files
+// generated by a request is bounded by maxBytes.
+func (o *outputs) process(ref *v1alpha1.CuratedPluginReference, output Output, res *pluginpb.CodeGeneratorResponse) error {
+	if o.files == nil {
+		o.files = map[string]bool{}
+	}
+
+	invalid := func(format string, args ...any) error {
+		return connect.NewError(
+			connect.CodeInternal,
+			fmt.Errorf("plugin '%s/%s:%s' generated an invalid file: %s", ref.GetOwner(), ref.GetName(), ref.GetVersion(), fmt.Sprintf(format, args...)),
+		)
+	}
+
+	// A file without a name continues the previous one, so is dropped along
+	// with it.
+	var dropped bool
+	files := res.GetFile()[:0]
+	for i, file := range res.GetFile() {
+		name := file.GetName()
+		if name == "" {
+			if i == 0 {
+				return invalid("the first file has no name")
+			}
+
+			if file.GetInsertionPoint() != "" {
+				return invalid("insertion point %q has no file name", file.GetInsertionPoint())
+			}
+
+			if !dropped {
+				files = append(files, file)
+				o.bytes += len(file.GetContent())
+			}
+
+			continue
+		}
+
+		if path.IsAbs(name) || slices.Contains(strings.Split(name, "/"), "..") {
+			return invalid("%q is not a relative path within the output directory", name)
+		}
+
+		if dropped = output.excludes(name); dropped {
+			continue
+		}
+
+		if output.StripPrefix != "" {
+			if stripped, ok := strings.CutPrefix(name, path.Clean(output.StripPrefix)+"/"); ok {
+				name = stripped
+			}
+		}
+
+		if output.Prefix != "" {
+			name = path.Join(output.Prefix, name)
+		}
+
+		file.Name = &name
+
+		if point := file.GetInsertionPoint(); point == "" {
+			o.files[name] = true
+		} else if o.insertions && !o.files[name] {
+			return invalid("insertion point %q is for %q, which was not generated earlier in the request", point, name)
+		}
+
+		files = append(files, file)
+		o.bytes += len(file.GetContent())
+	}
+	res.File = files
+
+	if o.maxBytes > 0 && o.bytes > o.maxBytes {
+		return connect.NewError(
+			connect.CodeResourceExhausted,
+			fmt.Errorf("generated files are larger than %d bytes", o.maxBytes),
+		)
+	}
+
+	return nil
+}
+
+func (output Output) excludes(name string) bool {
+	for _, pattern := range output.Exclude {
+		target := name
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(name)
+		}
+
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,211 @@
+package codegenerator
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+)
+
+// generated is a file of a CodeGeneratorResponse, "name" may be suffixed
+// with `#<insertion point>`.
+func generated(name, content string) *pluginpb.CodeGeneratorResponse_File {
+	file := &pluginpb.CodeGeneratorResponse_File{Content: proto.String(content)}
+
+	name, point, _ := strings.Cut(name, "#")
+	if name != "" {
+		file.Name = proto.String(name)
+	}
+
+	if point != "" {
+		file.InsertionPoint = proto.String(point)
+	}
+
+	return file
+}
+
+func TestOutputsProcess(t *testing.T) {
+	ref := &v1alpha1.CuratedPluginReference{Owner: "acme", Name: "protoc-gen-x", Version: "v1.0.0"}
+
+	tests := []struct {
+		name    string
+		outputs outputs
+		output  Output
+		files   []*pluginpb.CodeGeneratorResponse_File
+		// want are the names of the files kept, if the response is valid.
+		want     []string
+		wantCode connect.Code
+	}{
+		{
+			name:  "unchanged",
+			files: []*pluginpb.CodeGeneratorResponse_File{generated("a/b.go", ""), generated("c.go", "")},
+			want:  []string{"a/b.go", "c.go"},
+		},
+		{
+			name:   "strip prefix",
+			output: Output{StripPrefix: "github.com/acme/api/"},
+			files: []*pluginpb.CodeGeneratorResponse_File{
+				generated("github.com/acme/api/pet/v1/pet.go", ""),
+				generated("github.com/acme/apix/other.go", ""),
+				generated("github.com/acme/api", ""),
+			},
+			want: []string{"pet/v1/pet.go", "github.com/acme/apix/other.go", "github.com/acme/api"},
+		},
+		{
+			name:   "prefix",
+			output: Output{StripPrefix: "github.com/acme/api", Prefix: "gen/go"},
+			files:  []*pluginpb.CodeGeneratorResponse_File{generated("github.com/acme/api/pet.go", ""), generated("other.go", "")},
+			want:   []string{"gen/go/pet.go", "gen/go/other.go"},
+		},
+		{
+			name:   "exclude",
+			output: Output{Exclude: []string{"*_test.go", "internal/*.go"}},
+			files: []*pluginpb.CodeGeneratorResponse_File{
+				generated("a/a_test.go", ""),
+				generated("a/a.go", ""),
+				generated("internal/b.go", ""),
+				generated("a/internal/b.go", ""),
+			},
+			want: []string{"a/a.go", "a/internal/b.go"},
+		},
+		{
+			name:   "excluded with its continuations",
+			output: Output{Exclude: []string{"big.txt"}},
+			files: []*pluginpb.CodeGeneratorResponse_File{
+				generated("big.txt", "a"),
+				generated("", "b"),
+				generated("small.txt", "c"),
+				generated("", "d"),
+			},
+			want: []string{"small.txt", ""},
+		},
+		{
+			name:     "absolute name",
+			files:    []*pluginpb.CodeGeneratorResponse_File{generated("/etc/passwd", "")},
+			wantCode: connect.CodeInternal,
+		},
+		{
+			name:     "name with ..",
+			files:    []*pluginpb.CodeGeneratorResponse_File{generated("a/../../b.go", "")},
+			wantCode: connect.CodeInternal,
+		},
+		{
+			name:     ".. rejected before it is stripped",
+			output:   Output{StripPrefix: "..", Exclude: []string{"*.go"}},
+			files:    []*pluginpb.CodeGeneratorResponse_File{generated("../b.go", "")},
+			wantCode: connect.CodeInternal,
+		},
+		{
+			name:  "dots within names",
+			files: []*pluginpb.CodeGeneratorResponse_File{generated("a..b/..c.go", "")},
+			want:  []string{"a..b/..c.go"},
+		},
+		{
+			name:     "first file without a name",
+			files:    []*pluginpb.CodeGeneratorResponse_File{generated("", "")},
+			wantCode: connect.CodeInternal,
+		},
+		{
+			name:     "insertion point without a name",
+			files:    []*pluginpb.CodeGeneratorResponse_File{generated("a.go", ""), generated("#imports", "")},
+			wantCode: connect.CodeInternal,
+		},
+		{
+			name:    "insertion point for an earlier file",
+			outputs: outputs{insertions: true},
+			output:  Output{Prefix: "gen"},
+			files:   []*pluginpb.CodeGeneratorResponse_File{generated("a.go", ""), generated("a.go#imports", "")},
+			want:    []string{"gen/a.go", "gen/a.go"},
+		},
+		{
+			name:     "insertion point for a file not generated",
+			outputs:  outputs{insertions: true},
+			files:    []*pluginpb.CodeGeneratorResponse_File{generated("a.go#imports", ""), generated("a.go", "")},
+			wantCode: connect.CodeInternal,
+		},
+		{
+			name:  "insertion point left for the client",
+			files: []*pluginpb.CodeGeneratorResponse_File{generated("a.go#imports", "")},
+			want:  []string{"a.go"},
+		},
+		{
+			name:    "within max bytes",
+			outputs: outputs{maxBytes: 4},
+			output:  Output{Exclude: []string{"b"}},
+			files:   []*pluginpb.CodeGeneratorResponse_File{generated("a", "aa"), generated("", "aa"), generated("b", "bbbb")},
+			want:    []string{"a", ""},
+		},
+		{
+			name:     "over max bytes",
+			outputs:  outputs{maxBytes: 4},
+			files:    []*pluginpb.CodeGeneratorResponse_File{generated("a", "aa"), generated("", "aa"), generated("b", "b")},
+			wantCode: connect.CodeResourceExhausted,
+		},
+		{
+			name:     "max bytes counts earlier plugins",
+			outputs:  outputs{maxBytes: 4, bytes: 3},
+			files:    []*pluginpb.CodeGeneratorResponse_File{generated("a", "aa")},
+			wantCode: connect.CodeResourceExhausted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &pluginpb.CodeGeneratorResponse{File: tt.files}
+
+			err := tt.outputs.process(ref, tt.output, res)
+			if tt.wantCode != 0 {
+				if code := connect.CodeOf(err); code != tt.wantCode {
+					t.Fatalf("process() error = %v, want %v", err, tt.wantCode)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("process() error = %v", err)
+			}
+
+			var got []string
+			for _, file := range res.GetFile() {
+				got = append(got, file.GetName())
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("process() files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputsProcessAcrossPlugins(t *testing.T) {
+	ref := &v1alpha1.CuratedPluginReference{Owner: "acme", Name: "protoc-gen-x", Version: "v1.0.0"}
+	o := outputs{insertions: true, maxBytes: 10}
+
+	first := &pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{generated("a/b.go", "12345")}}
+	if err := o.process(ref, Output{Prefix: "gen"}, first); err != nil {
+		t.Fatalf("process() error = %v", err)
+	}
+
+	// Insertion points are for the names files were rewritten to.
+	second := &pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{generated("gen/a/b.go#imports", "123")}}
+	if err := o.process(ref, Output{}, second); err != nil {
+		t.Fatalf("process() error = %v", err)
+	}
+
+	third := &pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{generated("a/b.go#imports", "")}}
+	if err := o.process(ref, Output{}, third); connect.CodeOf(err) != connect.CodeInternal {
+		t.Fatalf("process() error = %v, want the original name to be rejected", err)
+	}
+
+	// The size of the request's output is bounded across plugins.
+	fourth := &pluginpb.CodeGeneratorResponse{File: []*pluginpb.CodeGeneratorResponse_File{generated("c.go", "123")}}
+	if err := o.process(ref, Output{}, fourth); connect.CodeOf(err) != connect.CodeResourceExhausted {
+		t.Fatalf("process() error = %v, want resource_exhausted", err)
+	}
+}
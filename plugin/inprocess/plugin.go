@@ -0,0 +1,74 @@
+package inprocess
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime/debug"
+
+	"github.com/bufbuild/protoplugin"
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// Handler generates the response for a CodeGeneratorRequest.
+type Handler func(ctx context.Context, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error)
+
+// Plugin wraps a plugin compiled into the server, calling its Handler
+// directly rather than spawning a process.
+type Plugin struct {
+	Name    string
+	Version string
+	Handler Handler
+}
+
+// Generate calls the Handler, returning an error rather than crashing the
+// server if it panics.
+func (p *Plugin) Generate(ctx context.Context, req *pluginpb.CodeGeneratorRequest) (res *pluginpb.CodeGeneratorResponse, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("plugin panicked", "plugin", p.Name, "version", p.Version, "panic", r, "stack", string(debug.Stack()))
+			res, err = nil, fmt.Errorf("plugin %s:%s panicked: %v", p.Name, p.Version, r)
+		}
+	}()
+
+	return p.Handler(ctx, req)
+}
+
+// Protoplugin adapts a protoplugin.Handler into a Handler.
+func Protoplugin(h protoplugin.Handler) Handler {
+	return func(ctx context.Context, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+		request, err := protoplugin.NewRequest(req)
+		if err != nil {
+			return nil, err
+		}
+
+		w := protoplugin.NewResponseWriter()
+		env := protoplugin.PluginEnv{Environ: os.Environ(), Stderr: os.Stderr}
+		if err := h.Handle(ctx, env, w, request); err != nil {
+			return nil, err
+		}
+
+		return w.ToCodeGeneratorResponse()
+	}
+}
+
+// Protogen adapts a protogen plugin function into a Handler, mirroring
+// protogen.Options.Run.
+func Protogen(opts protogen.Options, f func(*protogen.Plugin) error) Handler {
+	return func(_ context.Context, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+		gen, err := opts.New(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := f(gen); err != nil {
+			// Errors from the plugin function are reported to the caller in
+			// the response, as protoc would.
+			gen.Error(err)
+		}
+
+		return gen.Response(), nil
+	}
+}
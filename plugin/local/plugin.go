@@ -4,17 +4,28 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"log/slog"
+	"os"
 	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/pluginpb"
+
+	"github.com/CGA1123/codegenerator/metrics"
 )
 
+// waitDelay is how long a cancelled plugin has to exit before it is killed.
+const waitDelay = 10 * time.Second
+
 // Plugin wraps a plugin binary for local execution.
 type Plugin struct {
 	Cwd     string
 	Path    string
 	Args    []string
+	Owner   string
 	Name    string
 	Version string
 }
@@ -30,13 +41,25 @@ func (p *Plugin) Generate(ctx context.Context, req *pluginpb.CodeGeneratorReques
 
 	cmd := exec.CommandContext(ctx, p.Path, p.Args...)
 
+	// Give the plugin a chance to clean up when cancelled (e.g. the docker
+	// CLI stopping its container), before it is killed.
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = waitDelay
+
 	cmd.Stdin = bytes.NewReader(in)
-	cmd.Stderr = errout // io.Discard
+	cmd.Stderr = errout
 	cmd.Stdout = stdout
 	cmd.Dir = p.Cwd
 
-	if err := cmd.Run(); err != nil {
-		fmt.Println("execute plugin failed, errout:", errout)
+	err = cmd.Run()
+	if cmd.ProcessState != nil {
+		metrics.PluginExits.WithLabelValues(p.Owner, p.Name, p.Version, exitStatus(cmd.ProcessState)).Inc()
+	}
+
+	if err != nil {
+		slog.Error("executing plugin", "owner", p.Owner, "plugin", p.Name, "version", p.Version, "error", err, "stderr", errout.String())
 		return nil, err
 	}
 
@@ -47,3 +70,13 @@ func (p *Plugin) Generate(ctx context.Context, req *pluginpb.CodeGeneratorReques
 
 	return res, nil
 }
+
+// exitStatus describes how a process exited, by its exit code or the signal
+// which killed it.
+func exitStatus(state *os.ProcessState) string {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return status.Signal().String()
+	}
+
+	return strconv.Itoa(state.ExitCode())
+}
@@ -0,0 +1,67 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// ParseCompilerVersion parses a version of the form
+// `<major>[.<minor>[.<patch>]][-<suffix>]`, e.g. `1.47.2` or `25.1-rc2`, with
+// an optional leading `v`.
+func ParseCompilerVersion(version string) (*pluginpb.Version, error) {
+	numbers, suffix, _ := strings.Cut(strings.TrimPrefix(version, "v"), "-")
+
+	parts := strings.Split(numbers, ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid compiler version %q", version)
+	}
+
+	var values [3]int32
+	for i, part := range parts {
+		value, err := strconv.ParseInt(part, 10, 32)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid compiler version %q", version)
+		}
+
+		values[i] = int32(value)
+	}
+
+	v := &pluginpb.Version{
+		Major: proto.Int32(values[0]),
+		Minor: proto.Int32(values[1]),
+		Patch: proto.Int32(values[2]),
+	}
+
+	if suffix != "" {
+		v.Suffix = proto.String(suffix)
+	}
+
+	return v, nil
+}
+
+// CompilerVersioned is implemented by plugins whose registry records the
+// compiler version they are given, unless the server configures one for the
+// plugin.
+type CompilerVersioned interface {
+	Plugin
+	CompilerVersion() *pluginpb.Version
+}
+
+// WithCompilerVersion records "version" as the compiler version given to "p".
+func WithCompilerVersion(p Plugin, version *pluginpb.Version) CompilerVersioned {
+	return &compilerVersioned{Plugin: p, version: version}
+}
+
+type compilerVersioned struct {
+	Plugin
+
+	version *pluginpb.Version
+}
+
+func (p *compilerVersioned) CompilerVersion() *pluginpb.Version {
+	return p.version
+}
@@ -0,0 +1,114 @@
+package wasm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// NewRuntime builds a wazero runtime with WASI available, suitable for
+// running plugins.
+//
+// If "cache" is set, compiled modules are cached in that directory across
+// restarts.
+func NewRuntime(ctx context.Context, cache string) (wazero.Runtime, error) {
+	config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	if cache != "" {
+		c, err := wazero.NewCompilationCacheWithDir(cache)
+		if err != nil {
+			return nil, fmt.Errorf("creating compilation cache: %w", err)
+		}
+
+		config = config.WithCompilationCache(c)
+	}
+
+	r := wazero.NewRuntimeWithConfig(ctx, config)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
+		return nil, fmt.Errorf("instantiating wasi: %w", err)
+	}
+
+	return r, nil
+}
+
+// Compile compiles the WASI module at "path" into a Plugin.
+func Compile(ctx context.Context, runtime wazero.Runtime, path, name, version string) (*Plugin, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading module: %w", err)
+	}
+
+	module, err := runtime.CompileModule(ctx, b)
+	if err != nil {
+		return nil, fmt.Errorf("compiling module: %w", err)
+	}
+
+	return &Plugin{
+		Name:    name,
+		Version: version,
+		runtime: runtime,
+		module:  module,
+	}, nil
+}
+
+// Plugin wraps a WASI plugin module for in-process execution.
+//
+// Each generation runs in a fresh module instance, with the request on stdin
+// and the response read from stdout. The module has no access to the host
+// filesystem or network.
+type Plugin struct {
+	Name    string
+	Version string
+
+	runtime wazero.Runtime
+	module  wazero.CompiledModule
+}
+
+func (p *Plugin) Generate(ctx context.Context, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	in, err := proto.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling plugin request: %w", err)
+	}
+
+	stdout := &bytes.Buffer{}
+	errout := &bytes.Buffer{}
+
+	config := wazero.NewModuleConfig().
+		// Anonymous, so the same module can be instantiated concurrently.
+		WithName("").
+		WithArgs(p.Name).
+		WithStdin(bytes.NewReader(in)).
+		WithStdout(stdout).
+		WithStderr(errout).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader)
+
+	mod, err := p.runtime.InstantiateModule(ctx, p.module, config)
+	if mod != nil {
+		defer mod.Close(ctx)
+	}
+
+	var exitErr *sys.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 0) {
+		slog.Error("executing plugin", "plugin", p.Name, "version", p.Version, "runtime", "wasm", "error", err, "stderr", errout.String())
+		return nil, err
+	}
+
+	res := &pluginpb.CodeGeneratorResponse{}
+	if err := proto.Unmarshal(stdout.Bytes(), res); err != nil {
+		return nil, fmt.Errorf("unmarshaling plugin response: %w", err)
+	}
+
+	return res, nil
+}
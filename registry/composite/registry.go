@@ -0,0 +1,145 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+	"github.com/CGA1123/codegenerator/plugin"
+	"github.com/CGA1123/codegenerator/registry"
+)
+
+// Backend is a registry which can be referred to by name from a Rule.
+type Backend struct {
+	Name     string
+	Registry registry.Registry
+}
+
+// Rule routes plugins to a subset of the backends.
+//
+// Owner and Plugin are patterns matched using path.Match, an empty Plugin
+// matches all plugins of the owner.
+type Rule struct {
+	Owner    string
+	Plugin   string
+	Backends []string
+}
+
+// CompositeRegistry chains multiple registries together.
+//
+// It will panic if a rule refers to a backend which does not exist.
+//
+// For each plugin the first matching rule decides which backends are
+// consulted, and in what order. If no rule matches, all backends are
+// consulted in the order given. The first backend which provides the plugin
+// wins, a backend failing with anything other than registry.ErrNotFound stops
+// the search.
+func CompositeRegistry(backends []Backend, rules []Rule) *Registry {
+	r, err := buildCompositeRegistry(backends, rules)
+	if err != nil {
+		log.Fatalf("building composite registry: %v", err)
+	}
+
+	return r
+}
+
+func buildCompositeRegistry(backends []Backend, rules []Rule) (*Registry, error) {
+	byName := make(map[string]registry.Registry, len(backends))
+	for _, b := range backends {
+		if _, ok := byName[b.Name]; ok {
+			return nil, fmt.Errorf("duplicate backend %q", b.Name)
+		}
+
+		byName[b.Name] = b.Registry
+	}
+
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Owner, ""); err != nil {
+			return nil, fmt.Errorf("invalid owner pattern %q: %w", rule.Owner, err)
+		}
+
+		if _, err := path.Match(rule.Plugin, ""); err != nil {
+			return nil, fmt.Errorf("invalid plugin pattern %q: %w", rule.Plugin, err)
+		}
+
+		for _, name := range rule.Backends {
+			if _, ok := byName[name]; !ok {
+				return nil, fmt.Errorf("rule for %q refers to unknown backend %q", rule.Owner, name)
+			}
+		}
+	}
+
+	return &Registry{backends: backends, byName: byName, rules: rules}, nil
+}
+
+// Registry is the container which points to all available plugins.
+type Registry struct {
+	backends []Backend
+	byName   map[string]registry.Registry
+	rules    []Rule
+}
+
+// Get gets a plugin from the first backend which provides it.
+func (r *Registry) Get(ctx context.Context, ref *v1alpha1.CuratedPluginReference) (plugin.Plugin, error) {
+	names := r.route(ref.GetOwner(), ref.GetName())
+
+	for _, name := range names {
+		p, err := r.byName[name].Get(ctx, ref)
+		if errors.Is(err, registry.ErrNotFound) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%s registry: %w", name, err)
+		}
+
+		return p, nil
+	}
+
+	return nil, fmt.Errorf("%w '%s/%s:%s': not found in %s", registry.ErrNotFound, ref.GetOwner(), ref.GetName(), ref.GetVersion(), strings.Join(names, ", "))
+}
+
+// route returns the names of the backends to consult for a plugin, in order.
+func (r *Registry) route(owner, name string) []string {
+	for _, rule := range r.rules {
+		if ok, _ := path.Match(rule.Owner, owner); !ok {
+			continue
+		}
+
+		if rule.Plugin != "" {
+			if ok, _ := path.Match(rule.Plugin, name); !ok {
+				continue
+			}
+		}
+
+		return rule.Backends
+	}
+
+	names := make([]string, len(r.backends))
+	for i, b := range r.backends {
+		names[i] = b.Name
+	}
+
+	return names
+}
+
+// Check checks every backend which depends on an external service.
+func (r *Registry) Check(ctx context.Context) error {
+	var errs []error
+	for _, b := range r.backends {
+		c, ok := b.Registry.(registry.Checker)
+		if !ok {
+			continue
+		}
+
+		if err := c.Check(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s registry: %w", b.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
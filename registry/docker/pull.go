@@ -0,0 +1,135 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+	"github.com/CGA1123/codegenerator/metrics"
+	"github.com/CGA1123/codegenerator/plugin/local"
+	"github.com/CGA1123/codegenerator/registry"
+)
+
+// pullTimeout bounds how long an image pull may take, so a hung pull does
+// not block every request for the image.
+const pullTimeout = 10 * time.Minute
+
+// pullThrough holds the state for on-demand image pulls.
+//
+// Concurrent requests for the same image are collapsed into a single pull,
+// and images known to be present are remembered so we only ask the docker
+// daemon once per image.
+type pullThrough struct {
+	upstream string
+	allow    []string
+
+	group   singleflight.Group
+	present sync.Map
+}
+
+func (p *pullThrough) allowed(owner, name string) bool {
+	ref := owner + "/" + name
+	for _, pattern := range p.allow {
+		if ok, _ := path.Match(pattern, ref); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ensure makes sure "image" of the plugin "ref" is available to the docker
+// daemon, pulling it from "upstream" if required and the plugin is allowed.
+//
+// The pull itself is not bound to the lifetime of any single caller, so a
+// cancelled request does not abort a pull other requests are waiting on, but
+// is bounded by pullTimeout.
+func (p *pullThrough) ensure(ctx context.Context, ref *v1alpha1.CuratedPluginReference, image, upstream string) error {
+	_, ok := p.present.Load(image)
+	metrics.CacheRequests.WithLabelValues("docker", metrics.CacheResult(ok)).Inc()
+
+	if ok {
+		return nil
+	}
+
+	ch := p.group.DoChan(image, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), pullTimeout)
+		defer cancel()
+
+		if err := docker(ctx, "image", "inspect", "--format", "{{.Id}}", image); err == nil {
+			return nil, nil
+		}
+
+		if !p.allowed(ref.GetOwner(), ref.GetName()) {
+			return nil, fmt.Errorf("%w '%s/%s:%s': not present and not in pull-through allowlist", registry.ErrNotFound, ref.GetOwner(), ref.GetName(), ref.GetVersion())
+		}
+
+		slog.Info("pulling plugin image", "image", image, "upstream", upstream)
+
+		if err := docker(ctx, "pull", upstream); err != nil {
+			return nil, fmt.Errorf("pulling %s: %w", upstream, err)
+		}
+
+		if upstream != image {
+			if err := docker(ctx, "tag", upstream, image); err != nil {
+				return nil, fmt.Errorf("tagging %s as %s: %w", upstream, image, err)
+			}
+		}
+
+		return nil, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return res.Err
+		}
+
+		p.present.Store(image, struct{}{})
+
+		return nil
+	}
+}
+
+func docker(ctx context.Context, args ...string) error {
+	errout := &bytes.Buffer{}
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Stderr = errout
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker %s: %w: %s", args[0], err, strings.TrimSpace(errout.String()))
+	}
+
+	return nil
+}
+
+// pullPlugin makes sure the plugin image is present before running it.
+type pullPlugin struct {
+	*local.Plugin
+
+	pull     *pullThrough
+	ref      *v1alpha1.CuratedPluginReference
+	image    string
+	upstream string
+}
+
+func (p *pullPlugin) Generate(ctx context.Context, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	if err := p.pull.ensure(ctx, p.ref, p.image, p.upstream); err != nil {
+		return nil, err
+	}
+
+	return p.Plugin.Generate(ctx, req)
+}
@@ -1,12 +1,26 @@
 package docker
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
+	"sync"
+	"time"
 
 	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
 	"github.com/CGA1123/codegenerator/plugin"
 	"github.com/CGA1123/codegenerator/plugin/local"
+	"github.com/CGA1123/codegenerator/registry"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// inspectTimeout bounds how long checking for an image may take.
+	inspectTimeout = 10 * time.Second
+	// missingTTL is how long an image is remembered to be missing, so
+	// repeated lookups of plugins which are not provided don't each ask the
+	// docker daemon.
+	missingTTL = 10 * time.Second
 )
 
 // LocalRegistry reads the available plugins from the folder structure at
@@ -27,20 +41,58 @@ import (
 // There is an executable file at `<owner>/<plugin>/<version>/<plugin>`
 //
 // <version> is required to match `v1.2.3` (or `/v\d+\.\d+\.\d+`).
-func DockerRegistry(path string) *Registry {
-	return &Registry{registry: path}
+func DockerRegistry(path string, opts ...Option) *Registry {
+	r := &Registry{registry: path}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Option configures optional behaviour of a Registry.
+type Option func(*Registry)
+
+// WithPullThrough enables on-demand pulling of plugin images which are not
+// present locally.
+//
+// Images are pulled from "upstream" (e.g. `ghcr.io/acme`) using the same
+// `plugins-<owner>-<plugin>:<version>` naming scheme, and tagged into the
+// local registry path. Only plugins matching one of the "allow" patterns may
+// be pulled, patterns are of the form `<owner>/<plugin>` and are matched
+// using path.Match (e.g. `acme/*`). Images already present locally are run
+// whether or not they are allowed, other plugins are not found.
+func WithPullThrough(upstream string, allow []string) Option {
+	return func(r *Registry) {
+		r.pull = &pullThrough{
+			upstream: upstream,
+			allow:    allow,
+		}
+	}
 }
 
 // Registry is the container which points to all available plugins.
 type Registry struct {
 	registry string
+	pull     *pullThrough
+
+	// present are the images known to be present locally, and missing when
+	// images were last found to be missing.
+	present sync.Map
+	missing sync.Map
+	inspect singleflight.Group
 }
 
 // Get gets a plugin, if registered.
 //
 // * Version must be set.
 // * Revision must not be set.
-func (r *Registry) Get(ref *v1alpha1.CuratedPluginReference) (plugin.Plugin, error) {
+//
+// Without pull-through every plugin is provided, leaving `docker run` to pull
+// missing images. With pull-through, plugins whose image is neither present
+// locally nor may be pulled are not found, so other registries can be
+// consulted.
+func (r *Registry) Get(ctx context.Context, ref *v1alpha1.CuratedPluginReference) (plugin.Plugin, error) {
 	if ref.GetRevision() != 0 {
 		return nil, fmt.Errorf("setting version revision is not supported: got revision %v", ref.GetRevision())
 	}
@@ -50,13 +102,82 @@ func (r *Registry) Get(ref *v1alpha1.CuratedPluginReference) (plugin.Plugin, err
 	}
 
 	pluginRef := fmt.Sprintf("plugins-%s-%s:%s", ref.GetOwner(), ref.GetName(), ref.GetVersion())
+	image := filepath.Join(r.registry, pluginRef)
 
+	// --init makes sure the plugin exits when the docker CLI forwards SIGTERM
+	// to it on cancellation, so the container is stopped and removed.
 	p := &local.Plugin{
 		Path:    "docker",
-		Args:    []string{"run", "--rm", "-i", filepath.Join(r.registry, pluginRef)},
+		Args:    []string{"run", "--rm", "-i", "--init", image},
+		Owner:   ref.GetOwner(),
 		Name:    ref.GetName(),
 		Version: ref.GetVersion(),
 	}
 
-	return p, nil
+	if r.pull == nil {
+		return p, nil
+	}
+
+	exists, err := r.exists(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		return p, nil
+	}
+
+	if !r.pull.allowed(ref.GetOwner(), ref.GetName()) {
+		return nil, fmt.Errorf("%w '%s/%s:%s': image %s not present", registry.ErrNotFound, ref.GetOwner(), ref.GetName(), ref.GetVersion(), image)
+	}
+
+	return &pullPlugin{
+		Plugin:   p,
+		pull:     r.pull,
+		ref:      ref,
+		image:    image,
+		upstream: filepath.Join(r.pull.upstream, pluginRef),
+	}, nil
+}
+
+// exists reports whether "image" is present locally.
+//
+// Concurrent checks of the same image share a single `docker image inspect`,
+// which is not bound to the lifetime of any single caller, but is bounded by
+// inspectTimeout.
+func (r *Registry) exists(ctx context.Context, image string) (bool, error) {
+	if _, ok := r.present.Load(image); ok {
+		return true, nil
+	}
+
+	if at, ok := r.missing.Load(image); ok && time.Since(at.(time.Time)) < missingTTL {
+		return false, nil
+	}
+
+	ch := r.inspect.DoChan(image, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), inspectTimeout)
+		defer cancel()
+
+		if err := docker(ctx, "image", "inspect", "--format", "{{.Id}}", image); err != nil {
+			r.missing.Store(image, time.Now())
+			return false, nil
+		}
+
+		r.missing.Delete(image)
+		r.present.Store(image, struct{}{})
+
+		return true, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case res := <-ch:
+		return res.Val.(bool), nil
+	}
+}
+
+// Check checks the docker daemon is reachable.
+func (r *Registry) Check(ctx context.Context) error {
+	return docker(ctx, "version", "--format", "{{.Server.Version}}")
 }
@@ -0,0 +1,92 @@
+package inprocess
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+	"github.com/CGA1123/codegenerator/plugin"
+	"github.com/CGA1123/codegenerator/plugin/inprocess"
+	"github.com/CGA1123/codegenerator/registry"
+)
+
+// Default is the registry used by the package level Register function.
+//
+// Plugins compiled into the server can register themselves with it from an
+// init function, e.g.
+//
+//	func init() {
+//		inprocess.Register("acme", "protoc-gen-foo", "v1.0.0", handler)
+//	}
+var Default = InProcessRegistry()
+
+var semverRegex = regexp.MustCompile(`^v\d+\.\d+\.\d+$`)
+
+// Register registers a plugin handler with the Default registry.
+func Register(owner, name, version string, h inprocess.Handler) {
+	Default.Register(owner, name, version, h)
+}
+
+// InProcessRegistry creates an empty registry of plugins which are compiled
+// into the server and called directly, without spawning a process.
+func InProcessRegistry() *Registry {
+	return &Registry{registry: map[string]*inprocess.Plugin{}}
+}
+
+// Registry is the container which points to all available plugins.
+type Registry struct {
+	mu       sync.RWMutex
+	registry map[string]*inprocess.Plugin
+}
+
+// Register registers the handler for the `<owner>/<name>:<version>` plugin.
+//
+// It will panic if the plugin has already been registered.
+func (r *Registry) Register(owner, name, version string, h inprocess.Handler) {
+	if !semverRegex.MatchString(version) {
+		panic(fmt.Sprintf("registering %s/%s:%s: version must match v1.2.3", owner, name, version))
+	}
+
+	pluginRef := fmt.Sprintf("%s/%s:%s", owner, name, version)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.registry[pluginRef]; ok {
+		panic(fmt.Sprintf("registering %s: already registered", pluginRef))
+	}
+
+	r.registry[pluginRef] = &inprocess.Plugin{
+		Name:    name,
+		Version: version,
+		Handler: h,
+	}
+}
+
+// Get gets a plugin, if registered.
+//
+// * Version must be set.
+// * Revision must not be set.
+func (r *Registry) Get(_ context.Context, ref *v1alpha1.CuratedPluginReference) (plugin.Plugin, error) {
+	if ref.GetRevision() != 0 {
+		return nil, fmt.Errorf("setting version revision is not supported: got revision %v", ref.GetRevision())
+	}
+
+	if ref.GetVersion() == "" {
+		return nil, fmt.Errorf("not setting a version is not supported")
+	}
+
+	pluginRef := fmt.Sprintf("%s/%s:%s", ref.GetOwner(), ref.GetName(), ref.GetVersion())
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.registry[pluginRef]
+	if !ok {
+		return nil, fmt.Errorf("%w '%s': not registered", registry.ErrNotFound, pluginRef)
+	}
+
+	return p, nil
+}
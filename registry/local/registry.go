@@ -1,7 +1,10 @@
 package local
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"log/slog"
 	"os"
@@ -12,6 +15,9 @@ import (
 	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
 	"github.com/CGA1123/codegenerator/plugin"
 	"github.com/CGA1123/codegenerator/plugin/local"
+	"github.com/CGA1123/codegenerator/plugin/wasm"
+	"github.com/CGA1123/codegenerator/registry"
+	"github.com/tetratelabs/wazero"
 )
 
 // LocalRegistry reads the available plugins from the folder structure at
@@ -32,8 +38,19 @@ import (
 // There is an executable file at `<owner>/<plugin>/<version>/<plugin>`
 //
 // <version> is required to match `v1.2.3` (or `/v\d+\.\d+\.\d+`).
-func LocalRegistry(path string) *Registry {
-	r, err := buildLocalRegistry(path)
+//
+// Alternatively, the plugin may be a WASI module at
+// `<owner>/<plugin>/<version>/<plugin>.wasm`, which is run in-process.
+//
+// The compiler version given to the plugin may be set in
+// `<owner>/<plugin>/<version>/compiler_version`, e.g. `25.1`.
+func LocalRegistry(path string, opts ...Option) *Registry {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	r, err := buildLocalRegistry(path, o)
 	if err != nil {
 		log.Fatalf("building local registry: %v", err)
 	}
@@ -43,7 +60,69 @@ func LocalRegistry(path string) *Registry {
 
 var semverRegex = regexp.MustCompile(`^v\d+\.\d+\.\d+$`)
 
-func buildLocalRegistry(path string) (*Registry, error) {
+// Option configures optional behaviour of a Registry.
+type Option func(*options)
+
+// WithWasmCache caches compiled WASI modules in "dir" across restarts.
+func WithWasmCache(dir string) Option {
+	return func(o *options) {
+		o.wasmCache = dir
+	}
+}
+
+type options struct {
+	wasmCache string
+	runtime   wazero.Runtime
+}
+
+// buildPlugin builds the plugin found in "dir", preferring a native executable
+// over a WASI module.
+func (o *options) buildPlugin(dir, ownerName, pluginName, versionName string) (plugin.Plugin, error) {
+	binary := filepath.Join(dir, pluginName)
+	module := binary + ".wasm"
+
+	if _, err := os.Stat(binary); errors.Is(err, fs.ErrNotExist) {
+		if _, err := os.Stat(module); err == nil {
+			slog.Info("found plugin", "owner", ownerName, "plugin", pluginName, "version", versionName, "name", fmt.Sprintf("%s/%s:%s", ownerName, pluginName, versionName), "runtime", "wasm")
+
+			if o.runtime == nil {
+				o.runtime, err = wasm.NewRuntime(context.Background(), o.wasmCache)
+				if err != nil {
+					return nil, fmt.Errorf("creating wasm runtime: %w", err)
+				}
+			}
+
+			p, err := wasm.Compile(context.Background(), o.runtime, module, pluginName, versionName)
+			if err != nil {
+				return nil, fmt.Errorf("compiling %s/%s@%s: %w", ownerName, pluginName, versionName, err)
+			}
+
+			return p, nil
+		}
+	}
+
+	info, err := os.Stat(binary)
+	if err != nil {
+		return nil, fmt.Errorf("stating binary for %s/%s@%s: %w", ownerName, pluginName, versionName, err)
+	}
+
+	slog.Info("found plugin", "owner", ownerName, "plugin", pluginName, "version", versionName, "name", fmt.Sprintf("%s/%s:%s", ownerName, pluginName, versionName))
+
+	execable := (info.Mode().Perm() & 0111) != 0
+	if !execable {
+		return nil, fmt.Errorf("plugin %s/%s@%s (%s) is not executable", ownerName, pluginName, versionName, binary)
+	}
+
+	return &local.Plugin{
+		Cwd:     dir,
+		Path:    binary,
+		Owner:   ownerName,
+		Name:    pluginName,
+		Version: versionName,
+	}, nil
+}
+
+func buildLocalRegistry(path string, o *options) (*Registry, error) {
 	path, err := filepath.Abs(path)
 	if err != nil {
 		return nil, fmt.Errorf("expanding path: %w", err)
@@ -106,31 +185,16 @@ func buildLocalRegistry(path string) (*Registry, error) {
 					return nil, fmt.Errorf("expected %s/%s/%s/%s to be a directory", path, ownerName, pluginName, versionName)
 				}
 
-				info, err := os.Stat(filepath.Join(
-					path,
-					ownerName,
-					pluginName,
-					versionName,
-					pluginName,
-				))
-				if err != nil {
-					return nil, fmt.Errorf("stating binary for %s/%s@%s: %w", ownerName, pluginName, versionName, err)
-				}
-
-				slog.Info("found plugin", "owner", ownerName, "plugin", pluginName, "version", versionName, "name", fmt.Sprintf("%s/%s:%s", ownerName, pluginName, versionName))
+				dir := filepath.Join(path, ownerName, pluginName, versionName)
 
-				execable := (info.Mode().Perm() & 0111) != 0
-				if !execable {
-					return nil, fmt.Errorf("plugin %s/%s@%s (%s) is not executable", ownerName, pluginName, versionName, filepath.Join(
-						path, pluginName, versionName, pluginName,
-					))
+				p, err := o.buildPlugin(dir, ownerName, pluginName, versionName)
+				if err != nil {
+					return nil, err
 				}
 
-				p := &local.Plugin{
-					Cwd:     filepath.Join(path, ownerName, pluginName, versionName),
-					Path:    filepath.Join(path, ownerName, pluginName, versionName, pluginName),
-					Name:    pluginName,
-					Version: versionName,
+				p, err = withCompilerVersion(p, dir)
+				if err != nil {
+					return nil, fmt.Errorf("reading compiler version of %s/%s@%s: %w", ownerName, pluginName, versionName, err)
 				}
 
 				if _, ok := registry[ownerName]; !ok {
@@ -149,6 +213,26 @@ func buildLocalRegistry(path string) (*Registry, error) {
 	return &Registry{registry: registry}, nil
 }
 
+// withCompilerVersion records the compiler version in the
+// `compiler_version` file of "dir" as the version "p" is given, if the file
+// exists.
+func withCompilerVersion(p plugin.Plugin, dir string) (plugin.Plugin, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "compiler_version"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := plugin.ParseCompilerVersion(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	return plugin.WithCompilerVersion(p, version), nil
+}
+
 func isDotFile(f os.DirEntry) bool {
 	return strings.HasPrefix(f.Name(), ".")
 }
@@ -162,7 +246,7 @@ type Registry struct {
 //
 // * Version must be set.
 // * Revision must not be set.
-func (r *Registry) Get(ref *v1alpha1.CuratedPluginReference) (plugin.Plugin, error) {
+func (r *Registry) Get(_ context.Context, ref *v1alpha1.CuratedPluginReference) (plugin.Plugin, error) {
 	if ref.GetRevision() != 0 {
 		return nil, fmt.Errorf("setting version revision is not supported: got revision %v", ref.GetRevision())
 	}
@@ -175,17 +259,17 @@ func (r *Registry) Get(ref *v1alpha1.CuratedPluginReference) (plugin.Plugin, err
 
 	plugins, ok := r.registry[ref.GetOwner()]
 	if !ok {
-		return nil, fmt.Errorf("plugin not found '%s': owner not found", pluginRef)
+		return nil, fmt.Errorf("%w '%s': owner not found", registry.ErrNotFound, pluginRef)
 	}
 
 	versions, ok := plugins[ref.GetName()]
 	if !ok {
-		return nil, fmt.Errorf("plugin not found '%s': plugin not found", pluginRef)
+		return nil, fmt.Errorf("%w '%s': plugin not found", registry.ErrNotFound, pluginRef)
 	}
 
 	plugin, ok := versions[ref.GetVersion()]
 	if !ok {
-		return nil, fmt.Errorf("plugin not found '%s': version not found", pluginRef)
+		return nil, fmt.Errorf("%w '%s': version not found", registry.ErrNotFound, pluginRef)
 	}
 
 	return plugin, nil
@@ -0,0 +1,442 @@
+package oci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/CGA1123/codegenerator/metrics"
+)
+
+const (
+	mediaTypeLayerTar        = "application/vnd.oci.image.layer.v1.tar"
+	mediaTypeLayerTarGzip    = "application/vnd.oci.image.layer.v1.tar+gzip"
+	mediaTypeDockerLayerGzip = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+type imageLayout struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+type index struct {
+	Manifests []descriptor `json:"manifests"`
+}
+
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *platform         `json:"platform,omitempty"`
+}
+
+type platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+}
+
+type manifest struct {
+	Config descriptor   `json:"config"`
+	Layers []descriptor `json:"layers"`
+}
+
+type imageConfig struct {
+	Config struct {
+		Entrypoint []string `json:"Entrypoint"`
+		Cmd        []string `json:"Cmd"`
+		WorkingDir string   `json:"WorkingDir"`
+	} `json:"config"`
+}
+
+// unpacked is an image which has been extracted into the cache.
+type unpacked struct {
+	// argv is the command to run, with the executable resolved to its
+	// location on the host.
+	argv []string
+	// cwd is the working directory of the image on the host.
+	cwd string
+}
+
+var digestRegex = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// openBlob opens the blob referenced by "desc", the returned reader fails
+// with an error at EOF if the content does not match the descriptor.
+func (r *Registry) openBlob(desc descriptor) (io.ReadCloser, error) {
+	if !digestRegex.MatchString(desc.Digest) {
+		return nil, fmt.Errorf("unsupported digest %q", desc.Digest)
+	}
+
+	algorithm, encoded, _ := strings.Cut(desc.Digest, ":")
+
+	f, err := os.Open(filepath.Join(r.path, "blobs", algorithm, encoded))
+	if err != nil {
+		return nil, err
+	}
+
+	return &verifiedReader{
+		f:      f,
+		hash:   sha256.New(),
+		digest: encoded,
+		size:   desc.Size,
+	}, nil
+}
+
+func (r *Registry) readBlobJSON(desc descriptor, v any) error {
+	blob, err := r.openBlob(desc)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	b, err := io.ReadAll(blob)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+type verifiedReader struct {
+	f      *os.File
+	hash   hash.Hash
+	digest string
+	size   int64
+	read   int64
+}
+
+func (v *verifiedReader) Read(p []byte) (int, error) {
+	n, err := v.f.Read(p)
+	v.hash.Write(p[:n])
+	v.read += int64(n)
+
+	if errors.Is(err, io.EOF) {
+		if v.size != 0 && v.read != v.size {
+			return n, fmt.Errorf("blob %s: expected %d bytes, got %d", v.digest, v.size, v.read)
+		}
+
+		if got := hex.EncodeToString(v.hash.Sum(nil)); got != v.digest {
+			return n, fmt.Errorf("blob %s: digest mismatch, got %s", v.digest, got)
+		}
+	}
+
+	return n, err
+}
+
+func (v *verifiedReader) Close() error {
+	return v.f.Close()
+}
+
+// unpack extracts the image referenced by "desc" into the cache, unless it is
+// already present.
+//
+// Images are extracted into a temporary directory which is renamed into
+// place once complete, so a partially extracted image is never used.
+func (r *Registry) unpack(desc descriptor) (*unpacked, error) {
+	m := &manifest{}
+	if err := r.readBlobJSON(desc, m); err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+
+	config := &imageConfig{}
+	if err := r.readBlobJSON(m.Config, config); err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+
+	argv := append(append([]string{}, config.Config.Entrypoint...), config.Config.Cmd...)
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("image has no entrypoint or cmd")
+	}
+
+	_, encoded, _ := strings.Cut(desc.Digest, ":")
+	dir := filepath.Join(r.cache, "sha256", encoded)
+	rootfs := filepath.Join(dir, "rootfs")
+
+	_, err := os.Stat(dir)
+	metrics.CacheRequests.WithLabelValues("oci", metrics.CacheResult(err == nil)).Inc()
+
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Info("extracting plugin image", "digest", desc.Digest, "dir", dir)
+
+		if err := r.extract(m, dir); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("stating cache directory: %w", err)
+	}
+
+	executable := argv[0]
+	if !filepath.IsAbs(executable) {
+		executable = filepath.Join(config.Config.WorkingDir, executable)
+	}
+
+	argv[0] = rootPath(rootfs, executable)
+
+	return &unpacked{argv: argv, cwd: rootPath(rootfs, config.Config.WorkingDir)}, nil
+}
+
+func (r *Registry) extract(m *manifest, dir string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return fmt.Errorf("creating cache directory: %w", err)
+	}
+
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".extract-")
+	if err != nil {
+		return fmt.Errorf("creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	rootfs := filepath.Join(tmp, "rootfs")
+	if err := os.Mkdir(rootfs, 0o755); err != nil {
+		return fmt.Errorf("creating rootfs: %w", err)
+	}
+
+	for _, layer := range m.Layers {
+		if err := r.applyLayer(rootfs, layer); err != nil {
+			return fmt.Errorf("applying layer %s: %w", layer.Digest, err)
+		}
+	}
+
+	if err := os.Rename(tmp, dir); err != nil {
+		// Somebody else may have extracted the same image concurrently.
+		if _, statErr := os.Stat(dir); statErr == nil {
+			return nil
+		}
+
+		return fmt.Errorf("moving extracted image into place: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Registry) applyLayer(rootfs string, layer descriptor) error {
+	blob, err := r.openBlob(layer)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	var in io.Reader = blob
+	switch layer.MediaType {
+	case mediaTypeLayerTar:
+	case mediaTypeLayerTarGzip, mediaTypeDockerLayerGzip:
+		gz, err := gzip.NewReader(blob)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		in = gz
+	default:
+		return fmt.Errorf("unsupported layer media type %q", layer.MediaType)
+	}
+
+	if err := applyTar(rootfs, in); err != nil {
+		return err
+	}
+
+	// Drain the reader so the blob digest is verified.
+	if _, err := io.Copy(io.Discard, blob); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// applyTar extracts the entries of a layer tarball onto "rootfs".
+func applyTar(rootfs string, in io.Reader) error {
+	tr := tar.NewReader(in)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := applyEntry(rootfs, hdr, tr); err != nil {
+			return fmt.Errorf("extracting %s: %w", hdr.Name, err)
+		}
+	}
+}
+
+func applyEntry(rootfs string, hdr *tar.Header, r io.Reader) error {
+	target, err := securePath(rootfs, hdr.Name)
+	if err != nil {
+		return err
+	}
+	dir, base := filepath.Split(target)
+
+	switch {
+	case base == whiteoutOpaque:
+		entries, err := os.ReadDir(dir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		for _, entry := range entries {
+			if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	case strings.HasPrefix(base, whiteoutPrefix):
+		return os.RemoveAll(filepath.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)))
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	mode := hdr.FileInfo().Mode().Perm()
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		// Replace anything which is not a directory, rather than following a
+		// symlink.
+		if fi, err := os.Lstat(target); err == nil && !fi.IsDir() {
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+
+		if err := os.MkdirAll(target, 0o755); err != nil {
+			return err
+		}
+
+		return os.Chmod(target, mode|0o700)
+	case tar.TypeReg:
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, mode|0o600)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+
+		return f.Close()
+	case tar.TypeSymlink:
+		// Absolute links are relative to the image root, not the host, and
+		// relative links must not point outside of it.
+		linkname := hdr.Linkname
+		if filepath.IsAbs(linkname) {
+			rel, err := filepath.Rel(dir, rootPath(rootfs, linkname))
+			if err != nil {
+				return err
+			}
+
+			linkname = rel
+		} else if !within(rootfs, filepath.Join(dir, linkname)) {
+			return fmt.Errorf("link to %q escapes image root", hdr.Linkname)
+		}
+
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+
+		return os.Symlink(linkname, target)
+	case tar.TypeLink:
+		source, err := securePath(rootfs, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+
+		if err := os.RemoveAll(target); err != nil {
+			return err
+		}
+
+		return os.Link(source, target)
+	default:
+		// Devices, fifos, etc. are not useful to a plugin.
+		return nil
+	}
+}
+
+// rootPath joins "name" onto "rootfs", treating "name" as if "rootfs" were the
+// filesystem root so that it cannot escape it.
+func rootPath(rootfs, name string) string {
+	return filepath.Join(rootfs, filepath.Clean("/"+name))
+}
+
+// securePath joins "name" onto "rootfs" like rootPath, but also resolves the
+// symlinks already extracted in its parent directories, as if "rootfs" were
+// the filesystem root, so that writing to the returned path cannot escape it
+// through a chain of links. The final element of "name" is not resolved.
+func securePath(rootfs, name string) (string, error) {
+	parent, base := path.Split(path.Clean("/" + filepath.ToSlash(name)))
+
+	current := "/"
+	pending := strings.Split(parent, "/")
+	for links := 0; len(pending) > 0; {
+		part := pending[0]
+		pending = pending[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = path.Dir(current)
+			continue
+		}
+
+		next := path.Join(current, part)
+
+		fi, err := os.Lstat(filepath.Join(rootfs, filepath.FromSlash(next)))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+
+		if err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+			current = next
+			continue
+		}
+
+		if links++; links > maxLinks {
+			return "", fmt.Errorf("too many levels of symbolic links in %q", name)
+		}
+
+		link, err := os.Readlink(filepath.Join(rootfs, filepath.FromSlash(next)))
+		if err != nil {
+			return "", err
+		}
+
+		link = filepath.ToSlash(link)
+		if path.IsAbs(link) {
+			current = "/"
+		}
+
+		pending = append(strings.Split(link, "/"), pending...)
+	}
+
+	return filepath.Join(rootfs, filepath.FromSlash(path.Join(current, base))), nil
+}
+
+// maxLinks bounds the symlinks followed resolving a path, as the kernel does.
+const maxLinks = 40
+
+func within(rootfs, path string) bool {
+	return path == rootfs || strings.HasPrefix(path, rootfs+string(filepath.Separator))
+}
@@ -0,0 +1,211 @@
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// entry is a tar entry, of a regular file if no type is set.
+type entry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func layer(t *testing.T, entries ...entry) *bytes.Buffer {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0o644}
+		if hdr.Typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(e.content))
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			hdr.Mode = 0o755
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf
+}
+
+// escape are entries linking `e` to the parent of the image root through a
+// chain of links, each of which looks like it stays within the root.
+var escape = []entry{
+	{name: "d/", typeflag: tar.TypeDir},
+	{name: "d/up", typeflag: tar.TypeSymlink, linkname: ".."},
+	{name: "e", typeflag: tar.TypeSymlink, linkname: "d/up/.."},
+}
+
+func TestApplyTarContainsLinkChains(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		// inside is a file which must exist within the root afterwards.
+		inside string
+	}{
+		{
+			name:    "regular file",
+			entries: []entry{{name: "e/escaped", content: "x"}},
+			inside:  "escaped",
+		},
+		{
+			name:    "directory",
+			entries: []entry{{name: "e/escaped/", typeflag: tar.TypeDir}},
+			inside:  "escaped",
+		},
+		{
+			name:    "symlink",
+			entries: []entry{{name: "e/escaped", typeflag: tar.TypeSymlink, linkname: "d"}},
+			inside:  "escaped",
+		},
+		{
+			name: "hard link",
+			entries: []entry{
+				{name: "file", content: "x"},
+				{name: "e/escaped", typeflag: tar.TypeLink, linkname: "file"},
+			},
+			inside: "escaped",
+		},
+		{
+			name:    "absolute link",
+			entries: []entry{{name: "abs", typeflag: tar.TypeSymlink, linkname: "/"}, {name: "abs/e/escaped", content: "x"}},
+			inside:  "escaped",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			rootfs := filepath.Join(tmp, "rootfs")
+			if err := os.Mkdir(rootfs, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := applyTar(rootfs, layer(t, append(escape, tt.entries...)...)); err != nil {
+				t.Fatalf("applyTar() error = %v", err)
+			}
+
+			if _, err := os.Lstat(filepath.Join(tmp, "escaped")); err == nil {
+				t.Fatal("entry was written outside of the image root")
+			}
+
+			if _, err := os.Lstat(filepath.Join(rootfs, tt.inside)); err != nil {
+				t.Fatalf("entry was not written within the image root: %v", err)
+			}
+		})
+	}
+}
+
+func TestApplyTarRejectsEscapes(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{
+			name:    "relative symlink",
+			entries: []entry{{name: "out", typeflag: tar.TypeSymlink, linkname: "../../secret"}},
+		},
+		{
+			name:    "hard link through link chain",
+			entries: append(escape, entry{name: "stolen", typeflag: tar.TypeLink, linkname: "e/secret"}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp := t.TempDir()
+			rootfs := filepath.Join(tmp, "rootfs")
+			if err := os.Mkdir(rootfs, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(filepath.Join(tmp, "secret"), []byte("secret"), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := applyTar(rootfs, layer(t, tt.entries...)); err == nil {
+				t.Fatal("applyTar() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestApplyTarWhiteoutsStayWithinRoot(t *testing.T) {
+	tmp := t.TempDir()
+	rootfs := filepath.Join(tmp, "rootfs")
+	if err := os.Mkdir(rootfs, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	victim := filepath.Join(tmp, "victim")
+	if err := os.WriteFile(victim, []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := append(escape,
+		entry{name: "e/.wh.victim"},
+		entry{name: "e/.wh..wh..opq"},
+	)
+	if err := applyTar(rootfs, layer(t, entries...)); err != nil {
+		t.Fatalf("applyTar() error = %v", err)
+	}
+
+	if _, err := os.Stat(victim); err != nil {
+		t.Fatalf("file outside of the image root was removed: %v", err)
+	}
+}
+
+func TestApplyTarReplacesSymlinkWithDirectory(t *testing.T) {
+	tmp := t.TempDir()
+	rootfs := filepath.Join(tmp, "rootfs")
+	if err := os.Mkdir(rootfs, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chmod(tmp, 0o711); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := append(escape, entry{name: "e/", typeflag: tar.TypeDir})
+	if err := applyTar(rootfs, layer(t, entries...)); err != nil {
+		t.Fatalf("applyTar() error = %v", err)
+	}
+
+	fi, err := os.Lstat(filepath.Join(rootfs, "e"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fi.IsDir() {
+		t.Fatalf("e is %v, want a directory", fi.Mode())
+	}
+
+	outside, err := os.Stat(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if outside.Mode().Perm() != 0o711 {
+		t.Fatalf("mode outside of the image root changed to %v", outside.Mode().Perm())
+	}
+}
@@ -0,0 +1,219 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/protobuf/types/pluginpb"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+	"github.com/CGA1123/codegenerator/plugin"
+	"github.com/CGA1123/codegenerator/plugin/local"
+	"github.com/CGA1123/codegenerator/registry"
+)
+
+const (
+	mediaTypeImageIndex         = "application/vnd.oci.image.index.v1+json"
+	mediaTypeImageManifest      = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+
+	annotationRefName         = "org.opencontainers.image.ref.name"
+	annotationCompilerVersion = "com.github.cga1123.codegenerator.compiler-version"
+)
+
+// OCIRegistry reads the available plugins from the OCI image layout at
+// "path".
+//
+// It will panic if the image layout does not match our expectations.
+//
+// The expectation is that for a remote plugin request of
+// `<host>/<owner>/<plugin>:<version>`
+//
+// There is an image in the layout's index.json annotated with
+// `org.opencontainers.image.ref.name: <owner>/<plugin>:<version>`. The image
+// may also be annotated with the compiler version given to the plugin, e.g.
+// `com.github.cga1123.codegenerator.compiler-version: 25.1`.
+//
+// Images are extracted on first use into "cache" (or the user cache directory
+// if empty), keyed by their manifest digest, and their entrypoint is executed
+// directly on the host without a container runtime. Plugins must therefore be
+// built for the host platform, and are expected to be statically linked.
+func OCIRegistry(path, cache string) *Registry {
+	r, err := buildOCIRegistry(path, cache)
+	if err != nil {
+		log.Fatalf("building oci registry: %v", err)
+	}
+
+	return r
+}
+
+func buildOCIRegistry(path, cache string) (*Registry, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("expanding path: %w", err)
+	}
+
+	if cache == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("finding cache directory: %w", err)
+		}
+
+		cache = filepath.Join(dir, "codegenerator", "oci")
+	}
+
+	cache, err = filepath.Abs(cache)
+	if err != nil {
+		return nil, fmt.Errorf("expanding cache path: %w", err)
+	}
+
+	slog.Info("building oci registry", "path", path, "cache", cache)
+
+	layout := &imageLayout{}
+	if err := readJSON(filepath.Join(path, "oci-layout"), layout); err != nil {
+		return nil, fmt.Errorf("reading image layout: %w", err)
+	}
+
+	if layout.ImageLayoutVersion != "1.0.0" {
+		return nil, fmt.Errorf("unsupported image layout version %q", layout.ImageLayoutVersion)
+	}
+
+	r := &Registry{
+		path:            path,
+		cache:           cache,
+		manifest:        map[string]descriptor{},
+		compilerVersion: map[string]*pluginpb.Version{},
+	}
+
+	index := &index{}
+	if err := readJSON(filepath.Join(path, "index.json"), index); err != nil {
+		return nil, fmt.Errorf("reading image index: %w", err)
+	}
+
+	for _, desc := range index.Manifests {
+		name := desc.Annotations[annotationRefName]
+		if name == "" {
+			continue
+		}
+
+		manifest, err := r.resolve(desc)
+		if err != nil {
+			return nil, fmt.Errorf("resolving %s: %w", name, err)
+		}
+
+		if version := desc.Annotations[annotationCompilerVersion]; version != "" {
+			r.compilerVersion[name], err = plugin.ParseCompilerVersion(version)
+			if err != nil {
+				return nil, fmt.Errorf("reading compiler version of %s: %w", name, err)
+			}
+		}
+
+		slog.Info("found plugin", "name", name, "digest", manifest.Digest)
+
+		r.manifest[name] = manifest
+	}
+
+	if err := os.MkdirAll(cache, 0o755); err != nil {
+		return nil, fmt.Errorf("creating cache directory: %w", err)
+	}
+
+	return r, nil
+}
+
+// resolve finds the image manifest for the host platform, following image
+// indexes if required.
+func (r *Registry) resolve(desc descriptor) (descriptor, error) {
+	switch desc.MediaType {
+	case mediaTypeImageManifest, mediaTypeDockerManifest:
+		return desc, nil
+	case mediaTypeImageIndex, mediaTypeDockerManifestList:
+		index := &index{}
+		if err := r.readBlobJSON(desc, index); err != nil {
+			return descriptor{}, err
+		}
+
+		for _, m := range index.Manifests {
+			if m.Platform == nil || (m.Platform.OS == runtime.GOOS && m.Platform.Architecture == runtime.GOARCH) {
+				return r.resolve(m)
+			}
+		}
+
+		return descriptor{}, fmt.Errorf("no manifest for platform %s/%s", runtime.GOOS, runtime.GOARCH)
+	default:
+		return descriptor{}, fmt.Errorf("unsupported media type %q", desc.MediaType)
+	}
+}
+
+// Registry is the container which points to all available plugins.
+type Registry struct {
+	path     string
+	cache    string
+	manifest map[string]descriptor
+	// compilerVersion are the compiler versions images are annotated with.
+	compilerVersion map[string]*pluginpb.Version
+
+	extracting singleflight.Group
+}
+
+// Get gets a plugin, if registered, extracting it into the cache if this is
+// the first time it has been requested.
+//
+// * Version must be set.
+// * Revision must not be set.
+func (r *Registry) Get(_ context.Context, ref *v1alpha1.CuratedPluginReference) (plugin.Plugin, error) {
+	if ref.GetRevision() != 0 {
+		return nil, fmt.Errorf("setting version revision is not supported: got revision %v", ref.GetRevision())
+	}
+
+	if ref.GetVersion() == "" {
+		return nil, fmt.Errorf("not setting a version is not supported")
+	}
+
+	pluginRef := fmt.Sprintf("%s/%s:%s", ref.GetOwner(), ref.GetName(), ref.GetVersion())
+
+	desc, ok := r.manifest[pluginRef]
+	if !ok {
+		return nil, fmt.Errorf("%w '%s': image not found", registry.ErrNotFound, pluginRef)
+	}
+
+	res, err, _ := r.extracting.Do(desc.Digest, func() (any, error) {
+		return r.unpack(desc)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("extracting plugin '%s': %w", pluginRef, err)
+	}
+
+	img := res.(*unpacked)
+
+	p := &local.Plugin{
+		Cwd:     img.cwd,
+		Path:    img.argv[0],
+		Args:    img.argv[1:],
+		Owner:   ref.GetOwner(),
+		Name:    ref.GetName(),
+		Version: ref.GetVersion(),
+	}
+
+	if version, ok := r.compilerVersion[pluginRef]; ok {
+		return plugin.WithCompilerVersion(p, version), nil
+	}
+
+	return p, nil
+}
+
+func readJSON(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
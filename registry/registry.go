@@ -1,10 +1,26 @@
 package registry
 
 import (
+	"context"
+	"errors"
+
 	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
 	"github.com/CGA1123/codegenerator/plugin"
 )
 
+// ErrNotFound is wrapped by the errors returned from Registry.Get when the
+// registry does not provide the requested plugin, as opposed to failing to
+// load it.
+var ErrNotFound = errors.New("plugin not found")
+
 type Registry interface {
-	Get(ref *v1alpha1.CuratedPluginReference) (plugin.Plugin, error)
+	// Get looks up the plugin "ref", which may involve checking an external
+	// service, such as the docker daemon, for the duration of "ctx".
+	Get(ctx context.Context, ref *v1alpha1.CuratedPluginReference) (plugin.Plugin, error)
+}
+
+// Checker is implemented by registries which depend on an external service to
+// provide plugins, reporting whether that service is reachable.
+type Checker interface {
+	Check(ctx context.Context) error
 }
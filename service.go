@@ -2,17 +2,32 @@ package codegenerator
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"connectrpc.com/connect"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/descriptorpb"
 	"google.golang.org/protobuf/types/pluginpb"
 
+	"github.com/CGA1123/codegenerator/audit"
+	"github.com/CGA1123/codegenerator/auth"
 	imagev1 "github.com/CGA1123/codegenerator/gen/buf/alpha/image/v1"
 	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
 	"github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1/registryv1alpha1connect"
+	"github.com/CGA1123/codegenerator/managed"
+	"github.com/CGA1123/codegenerator/metrics"
+	"github.com/CGA1123/codegenerator/plugin"
 	"github.com/CGA1123/codegenerator/registry"
 	"github.com/bufbuild/protoplugin/protopluginutil"
 )
@@ -21,30 +36,234 @@ var _ registryv1alpha1connect.CodeGenerationServiceHandler = (*Service)(nil)
 
 type Service struct {
 	Registry registry.Registry
+
+	// Plugins looks up the configuration of a plugin, if set.
+	Plugins func(ref *v1alpha1.CuratedPluginReference) PluginConfig
+
+	// Authorizer decides whether the caller may use a plugin, if set.
+	Authorizer Authorizer
+
+	// Auditor records every GenerateCode call, if set.
+	Auditor Auditor
+
+	// Limiter enforces rate limits and concurrency quotas, if set.
+	Limiter Limiter
+
+	// RequestLimits bounds the contents of requests.
+	RequestLimits RequestLimits
+
+	// CompilerVersion is given to plugins as the version of the compiler,
+	// if unset the version of the buf CLI making the request is used.
+	CompilerVersion *pluginpb.Version
+
+	// ApplyInsertionPoints inserts content generated for insertion points
+	// into the files generated by earlier plugins of a request, rather than
+	// leaving it to the client.
+	ApplyInsertionPoints bool
+
+	flights flights
+
+	mu       sync.Mutex
+	inflight int
+	draining bool
+	idle     chan struct{}
+	abort    context.Context
+	cancel   context.CancelFunc
+}
+
+// Authorizer decides whether the caller may use a plugin, returning an error
+// if not.
+type Authorizer interface {
+	Authorize(ctx context.Context, ref *v1alpha1.CuratedPluginReference) error
+}
+
+// Limiter enforces rate limits and concurrency quotas.
+type Limiter interface {
+	// Allow is called for each authorized GenerateCode call, before the
+	// request is validated, with the caller and the plugins requested.
+	Allow(caller string, refs []*v1alpha1.CuratedPluginReference) error
+	// Acquire is called before running each plugin, returning a function to
+	// call once it completes.
+	Acquire(ctx context.Context) (func(), error)
+}
+
+// PluginConfig is the server side configuration of a plugin.
+type PluginConfig struct {
+	// Options are appended to the options requested by the caller.
+	Options []string
+	// DefaultOptions are added unless the caller sets an option of the same
+	// name.
+	DefaultOptions []string
+	// ForcedOptions are always added, replacing any option of the same name
+	// set by the caller.
+	ForcedOptions []string
+	// DeniedOptions are patterns of options, or option names, which callers
+	// may not set. `*` matches any characters, including `/`, and `?` any
+	// single character.
+	DeniedOptions []string
+	// Timeout bounds the execution of the plugin, if set.
+	Timeout time.Duration
+	// PruneImports only sends the plugin the files it is asked to generate,
+	// and those they transitively import, rather than the whole image.
+	PruneImports bool
+	// CompilerVersion overrides the compiler version given to the plugin,
+	// including any recorded by its registry.
+	CompilerVersion *pluginpb.Version
+	// Managed rewrites the file options of the descriptors given to the
+	// plugin, if set.
+	Managed *managed.Config
+	// Output post-processes the files generated by the plugin.
+	Output Output
+
+	// deniedOptions are the DeniedOptions patterns compiled by Compile.
+	deniedOptions []*regexp.Regexp
+}
+
+func (s *Service) pluginConfig(ref *v1alpha1.CuratedPluginReference) PluginConfig {
+	if s.Plugins == nil {
+		return PluginConfig{}
+	}
+
+	return s.Plugins(ref)
 }
 
 func (s *Service) GenerateCode(
 	ctx context.Context,
 	req *connect.Request[v1alpha1.GenerateCodeRequest],
 ) (*connect.Response[v1alpha1.GenerateCodeResponse], error) {
+	start := time.Now()
+	size := proto.Size(req.Msg)
+	metrics.RequestBytes.Observe(float64(size))
+
+	// Continue the caller's trace, if the request carries one.
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(req.Header()))
+	ctx, span := tracer.Start(ctx, strings.TrimPrefix(registryv1alpha1connect.CodeGenerationServiceGenerateCodeProcedure, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "connect_rpc"),
+			attribute.String("rpc.service", registryv1alpha1connect.CodeGenerationServiceName),
+			attribute.String("rpc.method", "GenerateCode"),
+			attribute.Int("codegenerator.request.plugins", len(req.Msg.GetRequests())),
+			attribute.Int("codegenerator.request.files", len(req.Msg.GetImage().GetFile())),
+			attribute.Int("codegenerator.request.size", size),
+		),
+	)
+
+	event := newAuditEvent(req)
+	res, err := s.generateCode(ctx, req, event)
+
+	code := "ok"
+	if err != nil {
+		code = connect.CodeOf(err).String()
+		event.Error = err.Error()
+	} else {
+		size := proto.Size(res.Msg)
+		metrics.ResponseBytes.Observe(float64(size))
+		span.SetAttributes(attribute.Int("codegenerator.response.size", size))
+	}
+
+	metrics.Requests.WithLabelValues(code).Inc()
+	metrics.RequestDuration.WithLabelValues(code).Observe(time.Since(start).Seconds())
+
+	span.SetAttributes(attribute.String("rpc.connect_rpc.error_code", code))
+	endSpan(span, err)
+
+	if s.Auditor != nil {
+		event.Duration = time.Since(start)
+		event.Code = code
+		s.Auditor.Audit(ctx, event)
+	}
+
+	return res, err
+}
+
+func (s *Service) generateCode(
+	ctx context.Context,
+	req *connect.Request[v1alpha1.GenerateCodeRequest],
+	event *audit.Event,
+) (*connect.Response[v1alpha1.GenerateCodeResponse], error) {
+	ctx, done, err := s.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
 	msg := req.Msg
-	responses := make([]*v1alpha1.PluginGenerationResponse, len(msg.GetRequests()))
+
+	// Check every plugin up front, so we don't do any work for a request
+	// which will be denied.
+	if s.Authorizer != nil {
+		for _, pluginRequest := range msg.GetRequests() {
+			if err := s.Authorizer.Authorize(ctx, pluginRequest.GetPluginReference()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Rate limits are enforced before validating the image, which is the
+	// most expensive work done before running any plugin.
+	if s.Limiter != nil {
+		refs := make([]*v1alpha1.CuratedPluginReference, len(msg.GetRequests()))
+		for i, pluginRequest := range msg.GetRequests() {
+			refs[i] = pluginRequest.GetPluginReference()
+		}
+
+		if err := s.Limiter.Allow(caller(ctx, req.Peer()), refs); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ValidateRequest(msg, s.RequestLimits); err != nil {
+		return nil, err
+	}
+
+	configs := make([]PluginConfig, len(msg.GetRequests()))
+	parameters := make([]string, len(msg.GetRequests()))
 	for i, pluginRequest := range msg.GetRequests() {
-		plugin, err := s.Registry.Get(pluginRequest.GetPluginReference())
+		ref := pluginRequest.GetPluginReference()
+		configs[i] = s.pluginConfig(ref)
+
+		parameter, err := configs[i].parameter(ref, pluginRequest.GetOptions())
 		if err != nil {
 			return nil, err
 		}
 
-		genReq, err := ImageToCodeGeneratorRequest(msg.GetImage(), pluginRequest)
+		parameters[i] = parameter
+	}
+
+	compilerVersion := s.compilerVersion(req.Header().Get("User-Agent"))
+	output := outputs{maxBytes: s.RequestLimits.MaxOutputBytes, insertions: s.ApplyInsertionPoints}
+	var inserted insertions
+
+	// The image is only hashed once, to identify identical executions of
+	// any of the plugins.
+	digest := sync.OnceValues(func() ([]byte, error) { return imageDigest(msg.GetImage()) })
+
+	responses := make([]*v1alpha1.PluginGenerationResponse, len(msg.GetRequests()))
+	for i, pluginRequest := range msg.GetRequests() {
+		pluginResponse, err := s.generatePlugin(ctx, msg.GetImage(), digest, pluginRequest, configs[i], parameters[i], compilerVersion, event.Plugins[i])
 		if err != nil {
 			return nil, err
 		}
 
-		pluginResponse, err := plugin.Generate(ctx, genReq)
-		if err != nil {
+		if err := output.process(pluginRequest.GetPluginReference(), configs[i].Output, pluginResponse); err != nil {
 			return nil, err
 		}
 
+		if s.ApplyInsertionPoints {
+			if err := inserted.apply(pluginRequest.GetPluginReference(), pluginResponse); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, file := range pluginResponse.GetFile() {
+			event.Plugins[i].Files++
+			event.Plugins[i].Bytes += len(file.GetContent())
+		}
+
+		event.Files += event.Plugins[i].Files
+		event.Bytes += event.Plugins[i].Bytes
+
 		responses[i] = &v1alpha1.PluginGenerationResponse{Response: pluginResponse}
 	}
 
@@ -54,6 +273,145 @@ func (s *Service) GenerateCode(
 		}), nil
 }
 
+// generatePlugin runs a single plugin of a GenerateCode request with
+// "parameter", recording how it was run in "audited". "digest" returns the
+// digest of "image", computed once for all the plugins of the request.
+func (s *Service) generatePlugin(ctx context.Context, image *imagev1.Image, digest func() ([]byte, error), pluginRequest *v1alpha1.PluginGenerationRequest, config PluginConfig, parameter string, compilerVersion *pluginpb.Version, audited *audit.Plugin) (*pluginpb.CodeGeneratorResponse, error) {
+	ref := pluginRequest.GetPluginReference()
+
+	_, span := tracer.Start(ctx, "ImageToCodeGeneratorRequest", pluginAttributes(ref))
+	genReq, err := ImageToCodeGeneratorRequest(image, pluginRequest)
+	if err == nil {
+		if config.Managed != nil {
+			RewriteFileOptions(config.Managed, image, genReq)
+		}
+
+		if config.PruneImports {
+			PruneProtoFiles(genReq)
+		}
+
+		span.SetAttributes(
+			attribute.Int("codegenerator.plugin.files_to_generate", len(genReq.GetFileToGenerate())),
+			attribute.Int("codegenerator.plugin.proto_files", len(genReq.GetProtoFile())),
+		)
+	}
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	genReq.CompilerVersion = compilerVersion
+	if config.CompilerVersion != nil {
+		genReq.CompilerVersion = config.CompilerVersion
+	}
+
+	genReq.Parameter = proto.String(parameter)
+
+	audited.Version = ref.GetVersion()
+	audited.Options = genReq.GetParameter()
+
+	sum, err := digest()
+	if err != nil {
+		return nil, err
+	}
+
+	key := flightKey(ref, sum, pluginRequest, config, genReq)
+
+	// Identical requests in flight share a single execution, which only
+	// takes up a single slot. Looking the plugin up may need an external
+	// service, such as the docker daemon, so is done within the slot too.
+	return s.flights.do(ctx, key, s.track, func(ctx context.Context) (*pluginpb.CodeGeneratorResponse, error) {
+		if s.Limiter != nil {
+			release, err := s.Limiter.Acquire(ctx)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+		}
+
+		_, span := tracer.Start(ctx, "registry.Get", pluginAttributes(ref))
+		p, err := s.Registry.Get(ctx, ref)
+		endSpan(span, err)
+		if err != nil {
+			return nil, err
+		}
+
+		// The compiler version recorded by the registry is the same for
+		// every execution of the plugin, so needn't be part of the key.
+		if v, ok := p.(plugin.CompilerVersioned); ok && config.CompilerVersion == nil {
+			genReq.CompilerVersion = v.CompilerVersion()
+		}
+
+		return generate(ctx, ref, p, genReq, config.Timeout)
+	})
+}
+
+// caller identifies the caller for rate limiting, anonymous callers are
+// identified by their IP.
+func caller(ctx context.Context, peer connect.Peer) string {
+	if id := auth.FromContext(ctx); id != nil {
+		return id.String()
+	}
+
+	return "ip:" + clientIP(peer.Addr)
+}
+
+func generate(ctx context.Context, ref *v1alpha1.CuratedPluginReference, p plugin.Plugin, req *pluginpb.CodeGeneratorRequest, timeout time.Duration) (res *pluginpb.CodeGeneratorResponse, err error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ctx, span := tracer.Start(ctx, "plugin.Generate", pluginAttributes(ref), trace.WithAttributes(
+		attribute.Int("codegenerator.plugin.request.size", proto.Size(req)),
+	))
+	defer func() {
+		if res != nil {
+			span.SetAttributes(
+				attribute.Int("codegenerator.plugin.response.files", len(res.GetFile())),
+				attribute.Int("codegenerator.plugin.response.size", proto.Size(res)),
+			)
+
+			if res.GetError() != "" {
+				span.SetStatus(codes.Error, res.GetError())
+			}
+		}
+
+		endSpan(span, err)
+	}()
+
+	labels := []string{ref.GetOwner(), ref.GetName(), ref.GetVersion()}
+
+	inflight := metrics.PluginsInFlight.WithLabelValues(labels...)
+	inflight.Inc()
+	defer inflight.Dec()
+
+	start := time.Now()
+	res, err = p.Generate(ctx, req)
+	if err == nil && res == nil {
+		err = connect.NewError(connect.CodeInternal, errors.New("plugin returned no response"))
+	}
+	metrics.PluginDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+
+	status := "ok"
+	switch {
+	case err == nil && res.GetError() != "":
+		status = "failed"
+	case err == nil:
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		status = "timeout"
+	case errors.Is(ctx.Err(), context.Canceled):
+		status = "canceled"
+	default:
+		status = "error"
+	}
+
+	metrics.PluginExecutions.WithLabelValues(append(labels, status)...).Inc()
+
+	return res, err
+}
+
 func shouldGenerate(img *imagev1.ImageFile, plug *v1alpha1.PluginGenerationRequest) bool {
 	// Always generate non-imports.
 	if !img.GetBufExtension().GetIsImport() {
@@ -150,3 +508,50 @@ func ImageToCodeGeneratorRequest(image *imagev1.Image, plug *v1alpha1.PluginGene
 
 	return request, nil
 }
+
+// RewriteFileOptions rewrites the file options of the descriptors of a
+// CodeGeneratorRequest built from "image", with "config".
+func RewriteFileOptions(config *managed.Config, image *imagev1.Image, request *pluginpb.CodeGeneratorRequest) {
+	modules := make(map[string]string, len(image.GetFile()))
+	for _, file := range image.GetFile() {
+		modules[file.GetName()] = moduleName(file)
+	}
+
+	for _, file := range request.GetProtoFile() {
+		config.Rewrite(modules[file.GetName()], file)
+	}
+
+	for _, file := range request.GetSourceFileDescriptors() {
+		config.Rewrite(modules[file.GetName()], file)
+	}
+}
+
+// PruneProtoFiles drops the files of a CodeGeneratorRequest which are not
+// transitively imported by any of the files to generate.
+//
+// ProtoFile is expected to be in dependency order, as it is when built from a
+// valid image, which the remaining files keep.
+func PruneProtoFiles(request *pluginpb.CodeGeneratorRequest) {
+	files := make(map[string]*descriptorpb.FileDescriptorProto, len(request.GetProtoFile()))
+	for _, file := range request.GetProtoFile() {
+		files[file.GetName()] = file
+	}
+
+	needed := make(map[string]bool, len(files))
+	queue := slices.Clone(request.GetFileToGenerate())
+	for len(queue) > 0 {
+		name := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		if needed[name] {
+			continue
+		}
+		needed[name] = true
+
+		queue = append(queue, files[name].GetDependency()...)
+	}
+
+	request.ProtoFile = slices.DeleteFunc(request.ProtoFile, func(file *descriptorpb.FileDescriptorProto) bool {
+		return !needed[file.GetName()]
+	})
+}
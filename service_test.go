@@ -0,0 +1,125 @@
+package codegenerator
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+func protoFile(name string, deps ...string) *descriptorpb.FileDescriptorProto {
+	return &descriptorpb.FileDescriptorProto{Name: proto.String(name), Dependency: deps}
+}
+
+func TestPruneProtoFiles(t *testing.T) {
+	// public.proto is imported publicly, and weak.proto weakly, by b.proto.
+	b := protoFile("b.proto", "c.proto", "public.proto", "weak.proto")
+	b.PublicDependency = []int32{1}
+	b.WeakDependency = []int32{2}
+
+	files := []*descriptorpb.FileDescriptorProto{
+		protoFile("google/protobuf/descriptor.proto"),
+		protoFile("d.proto"),
+		protoFile("c.proto", "d.proto"),
+		protoFile("public.proto", "public_dep.proto"),
+		protoFile("public_dep.proto"),
+		protoFile("weak.proto"),
+		b,
+		protoFile("unused.proto", "d.proto"),
+		protoFile("a.proto", "b.proto"),
+		protoFile("other.proto", "google/protobuf/descriptor.proto"),
+	}
+
+	tests := []struct {
+		name     string
+		generate []string
+		want     []string
+	}{
+		{
+			name:     "transitive imports",
+			generate: []string{"a.proto"},
+			want:     []string{"d.proto", "c.proto", "public.proto", "public_dep.proto", "weak.proto", "b.proto", "a.proto"},
+		},
+		{
+			name:     "several files",
+			generate: []string{"c.proto", "other.proto"},
+			want:     []string{"google/protobuf/descriptor.proto", "d.proto", "c.proto", "other.proto"},
+		},
+		{
+			name:     "shared imports",
+			generate: []string{"unused.proto", "c.proto"},
+			want:     []string{"d.proto", "c.proto", "unused.proto"},
+		},
+		{
+			name:     "nothing imported",
+			generate: []string{"d.proto"},
+			want:     []string{"d.proto"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &pluginpb.CodeGeneratorRequest{FileToGenerate: tt.generate, ProtoFile: slices.Clone(files)}
+			PruneProtoFiles(req)
+
+			// Files keep their order, dependencies first.
+			var got []string
+			for _, file := range req.GetProtoFile() {
+				got = append(got, file.GetName())
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("PruneProtoFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPruneProtoFilesMissingDependency(t *testing.T) {
+	req := &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"a.proto", "missing_generated.proto"},
+		ProtoFile: []*descriptorpb.FileDescriptorProto{
+			protoFile("b.proto", "missing.proto"),
+			protoFile("a.proto", "b.proto"),
+			protoFile("c.proto"),
+		},
+	}
+
+	PruneProtoFiles(req)
+
+	var got []string
+	for _, file := range req.GetProtoFile() {
+		got = append(got, file.GetName())
+	}
+
+	if want := []string{"b.proto", "a.proto"}; !slices.Equal(got, want) {
+		t.Fatalf("PruneProtoFiles() = %v, want %v", got, want)
+	}
+}
+
+// BenchmarkPruneProtoFiles prunes an image of 1000 files, each importing the
+// 10 before it, to the closure of a single file importing half of them.
+func BenchmarkPruneProtoFiles(b *testing.B) {
+	files := make([]*descriptorpb.FileDescriptorProto, 1000)
+	for i := range files {
+		var deps []string
+		for j := max(0, i-10); j < i; j++ {
+			deps = append(deps, fmt.Sprintf("file%d.proto", j))
+		}
+
+		files[i] = protoFile(fmt.Sprintf("file%d.proto", i), deps...)
+	}
+
+	b.ReportAllocs()
+	for range b.N {
+		req := &pluginpb.CodeGeneratorRequest{FileToGenerate: []string{"file500.proto"}, ProtoFile: slices.Clone(files)}
+		PruneProtoFiles(req)
+
+		if len(req.GetProtoFile()) != 501 {
+			b.Fatalf("PruneProtoFiles() kept %d files, want 501", len(req.GetProtoFile()))
+		}
+	}
+}
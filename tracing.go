@@ -0,0 +1,32 @@
+package codegenerator
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+)
+
+// tracer records spans with the globally registered TracerProvider, so spans
+// are only exported once the server has configured one.
+var tracer = otel.Tracer("github.com/CGA1123/codegenerator")
+
+func pluginAttributes(ref *v1alpha1.CuratedPluginReference) trace.SpanStartEventOption {
+	return trace.WithAttributes(
+		attribute.String("codegenerator.plugin.owner", ref.GetOwner()),
+		attribute.String("codegenerator.plugin.name", ref.GetName()),
+		attribute.String("codegenerator.plugin.version", ref.GetVersion()),
+	)
+}
+
+// endSpan ends "span", marking it as failed if "err" is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
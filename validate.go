@@ -0,0 +1,135 @@
+package codegenerator
+
+import (
+	"errors"
+	"fmt"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+
+	imagev1 "github.com/CGA1123/codegenerator/gen/buf/alpha/image/v1"
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+)
+
+// RequestLimits bounds the contents of GenerateCode requests, and their
+// output, limits which are zero are not enforced.
+type RequestLimits struct {
+	// MaxRequestBytes bounds the encoded size of the request. It is only
+	// checked once the request has been read and decoded, so servers should
+	// also bound the bytes read, e.g. with connect.WithReadMaxBytes.
+	MaxRequestBytes int
+	// MaxFiles bounds the number of files in the image.
+	MaxFiles int
+	// MaxPlugins bounds the number of plugins requested.
+	MaxPlugins int
+	// MaxOutputBytes bounds the total size of the files generated.
+	MaxOutputBytes int
+}
+
+// ValidateRequest checks a GenerateCode request is within "limits", and
+// that its image is well-formed, before any plugin is run.
+//
+// The image must have at least one file which is not an import, unique file
+// names, and each file's dependencies must appear before it. Files must be
+// valid descriptors, with all references resolved.
+//
+// Errors are Connect `invalid_argument` errors, naming the offending field.
+func ValidateRequest(req *v1alpha1.GenerateCodeRequest, limits RequestLimits) error {
+	if err := validateRequest(req, limits); err != nil {
+		return connect.NewError(connect.CodeInvalidArgument, err)
+	}
+
+	return nil
+}
+
+func validateRequest(req *v1alpha1.GenerateCodeRequest, limits RequestLimits) error {
+	files := req.GetImage().GetFile()
+
+	if limits.MaxRequestBytes > 0 {
+		if size := proto.Size(req); size > limits.MaxRequestBytes {
+			return fmt.Errorf("request: request is %d bytes, at most %d are allowed", size, limits.MaxRequestBytes)
+		}
+	}
+
+	if len(req.GetRequests()) == 0 {
+		return errors.New("requests: must not be empty")
+	}
+
+	if limits.MaxPlugins > 0 && len(req.GetRequests()) > limits.MaxPlugins {
+		return fmt.Errorf("requests: %d plugins requested, at most %d are allowed", len(req.GetRequests()), limits.MaxPlugins)
+	}
+
+	for i, pluginRequest := range req.GetRequests() {
+		ref := pluginRequest.GetPluginReference()
+		if ref.GetOwner() == "" || ref.GetName() == "" {
+			return fmt.Errorf("requests[%d].plugin_reference: owner and name must be set", i)
+		}
+	}
+
+	if len(files) == 0 {
+		return errors.New("image.file: must not be empty")
+	}
+
+	if limits.MaxFiles > 0 && len(files) > limits.MaxFiles {
+		return fmt.Errorf("image.file: image has %d files, at most %d are allowed", len(files), limits.MaxFiles)
+	}
+
+	var generate bool
+	seen := make(map[string]int, len(files))
+	registry := &protoregistry.Files{}
+
+	for i, file := range files {
+		name := file.GetName()
+		field := fmt.Sprintf("image.file[%d]", i)
+
+		if name == "" {
+			return fmt.Errorf("%s.name: must be set", field)
+		}
+
+		if j, ok := seen[name]; ok {
+			return fmt.Errorf("%s.name: duplicate file %q, also image.file[%d]", field, name, j)
+		}
+
+		for j, dep := range file.GetDependency() {
+			if _, ok := seen[dep]; ok {
+				continue
+			}
+
+			if dep == name || !contains(files[i+1:], dep) {
+				return fmt.Errorf("%s.dependency[%d]: %q imports %q, which is not in the image", field, j, name, dep)
+			}
+
+			return fmt.Errorf("%s.dependency[%d]: %q imports %q, which must appear before it in the image", field, j, name, dep)
+		}
+
+		fd, err := protodesc.NewFile(ImageFileToDescriptor(file), registry)
+		if err != nil {
+			return fmt.Errorf("%s: invalid descriptor %q: %w", field, name, err)
+		}
+
+		if err := registry.RegisterFile(fd); err != nil {
+			return fmt.Errorf("%s: invalid descriptor %q: %w", field, name, err)
+		}
+
+		seen[name] = i
+		generate = generate || !file.GetBufExtension().GetIsImport()
+	}
+
+	if !generate {
+		return errors.New("image.file: all files are imports, there are no files to generate")
+	}
+
+	return nil
+}
+
+func contains(files []*imagev1.ImageFile, name string) bool {
+	for _, file := range files {
+		if file.GetName() == name {
+			return true
+		}
+	}
+
+	return false
+}
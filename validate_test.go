@@ -0,0 +1,193 @@
+package codegenerator
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	imagev1 "github.com/CGA1123/codegenerator/gen/buf/alpha/image/v1"
+	v1alpha1 "github.com/CGA1123/codegenerator/gen/buf/alpha/registry/v1alpha1"
+)
+
+// imageFile is a proto3 file of package "acme", with a message named after
+// the file, and a field of each message it is given.
+func imageFile(name string, deps []string, fieldTypes ...string) *imagev1.ImageFile {
+	message := &descriptorpb.DescriptorProto{Name: proto.String(strings.ToUpper(strings.TrimSuffix(name, ".proto")))}
+	for i, typ := range fieldTypes {
+		message.Field = append(message.Field, &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(strings.ToLower(typ)),
+			Number:   proto.Int32(int32(i + 1)),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+			TypeName: proto.String(".acme." + typ),
+		})
+	}
+
+	return &imagev1.ImageFile{
+		Name:        proto.String(name),
+		Package:     proto.String("acme"),
+		Syntax:      proto.String("proto3"),
+		Dependency:  deps,
+		MessageType: []*descriptorpb.DescriptorProto{message},
+	}
+}
+
+func asImport(file *imagev1.ImageFile) *imagev1.ImageFile {
+	file.BufExtension = &imagev1.ImageFileExtension{IsImport: proto.Bool(true)}
+
+	return file
+}
+
+func TestValidateRequest(t *testing.T) {
+	plugin := &v1alpha1.PluginGenerationRequest{
+		PluginReference: &v1alpha1.CuratedPluginReference{Owner: "acme", Name: "protoc-gen-x", Version: "v1.0.0"},
+	}
+
+	request := func(files ...*imagev1.ImageFile) *v1alpha1.GenerateCodeRequest {
+		return &v1alpha1.GenerateCodeRequest{
+			Image:    &imagev1.Image{File: files},
+			Requests: []*v1alpha1.PluginGenerationRequest{plugin},
+		}
+	}
+
+	valid := request(asImport(imageFile("a.proto", nil)), imageFile("b.proto", []string{"a.proto"}, "A"))
+
+	tests := []struct {
+		name   string
+		req    *v1alpha1.GenerateCodeRequest
+		limits RequestLimits
+		// wantField is the field named by the error, if the request is
+		// invalid.
+		wantField string
+	}{
+		{
+			name: "valid",
+			req:  valid,
+		},
+		{
+			name:   "within limits",
+			req:    valid,
+			limits: RequestLimits{MaxRequestBytes: proto.Size(valid), MaxFiles: 2, MaxPlugins: 1},
+		},
+		{
+			name:      "request too large",
+			req:       valid,
+			limits:    RequestLimits{MaxRequestBytes: proto.Size(valid) - 1},
+			wantField: "request",
+		},
+		{
+			name:      "no plugins",
+			req:       &v1alpha1.GenerateCodeRequest{Image: valid.GetImage()},
+			wantField: "requests",
+		},
+		{
+			name: "too many plugins",
+			req: &v1alpha1.GenerateCodeRequest{
+				Image:    valid.GetImage(),
+				Requests: []*v1alpha1.PluginGenerationRequest{plugin, plugin},
+			},
+			limits:    RequestLimits{MaxPlugins: 1},
+			wantField: "requests",
+		},
+		{
+			name: "plugin without name",
+			req: &v1alpha1.GenerateCodeRequest{
+				Image: valid.GetImage(),
+				Requests: []*v1alpha1.PluginGenerationRequest{plugin, {
+					PluginReference: &v1alpha1.CuratedPluginReference{Owner: "acme"},
+				}},
+			},
+			wantField: "requests[1].plugin_reference",
+		},
+		{
+			name:      "no files",
+			req:       request(),
+			wantField: "image.file",
+		},
+		{
+			name:      "too many files",
+			req:       valid,
+			limits:    RequestLimits{MaxFiles: 1},
+			wantField: "image.file",
+		},
+		{
+			name:      "no files to generate",
+			req:       request(asImport(imageFile("a.proto", nil)), asImport(imageFile("b.proto", []string{"a.proto"}, "A"))),
+			wantField: "image.file",
+		},
+		{
+			name:      "file without name",
+			req:       request(imageFile("a.proto", nil), &imagev1.ImageFile{}),
+			wantField: "image.file[1].name",
+		},
+		{
+			name:      "duplicate names",
+			req:       request(imageFile("a.proto", nil), imageFile("b.proto", nil), imageFile("a.proto", nil)),
+			wantField: "image.file[2].name",
+		},
+		{
+			name:      "dependency after the file importing it",
+			req:       request(imageFile("b.proto", []string{"a.proto"}, "A"), asImport(imageFile("a.proto", nil))),
+			wantField: "image.file[0].dependency[0]",
+		},
+		{
+			name:      "missing dependency",
+			req:       request(imageFile("b.proto", []string{"c.proto", "a.proto"})),
+			wantField: "image.file[0].dependency[0]",
+		},
+		{
+			name:      "importing itself",
+			req:       request(imageFile("a.proto", []string{"a.proto"})),
+			wantField: "image.file[0].dependency[0]",
+		},
+		{
+			name:      "unresolved type",
+			req:       request(imageFile("a.proto", nil), imageFile("b.proto", []string{"a.proto"}, "C")),
+			wantField: "image.file[1]",
+		},
+		{
+			name:      "type from a file not imported",
+			req:       request(imageFile("a.proto", nil), imageFile("b.proto", nil, "A")),
+			wantField: "image.file[1]",
+		},
+		{
+			name: "invalid syntax",
+			req: request(&imagev1.ImageFile{
+				Name:   proto.String("a.proto"),
+				Syntax: proto.String("proto4"),
+			}),
+			wantField: "image.file[0]",
+		},
+		{
+			name:      "conflicting declarations",
+			req:       request(imageFile("a.proto", nil), &imagev1.ImageFile{Name: proto.String("c.proto"), Package: proto.String("acme"), Syntax: proto.String("proto3"), MessageType: imageFile("a.proto", nil).GetMessageType()}),
+			wantField: "image.file[1]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRequest(tt.req, tt.limits)
+			if tt.wantField == "" {
+				if err != nil {
+					t.Fatalf("ValidateRequest() error = %v", err)
+				}
+
+				return
+			}
+
+			if code := connect.CodeOf(err); code != connect.CodeInvalidArgument {
+				t.Fatalf("ValidateRequest() error = %v, want invalid_argument", err)
+			}
+
+			var cerr *connect.Error
+			if !errors.As(err, &cerr) || !strings.HasPrefix(cerr.Message(), tt.wantField+":") {
+				t.Fatalf("ValidateRequest() error = %q, want it to name %s", cerr.Message(), tt.wantField)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package codegenerator
+
+import (
+	"google.golang.org/protobuf/types/pluginpb"
+
+	"github.com/CGA1123/codegenerator/plugin"
+)
+
+// compilerVersion is the compiler version given to plugins for a request
+// from "userAgent", the configured version or else that of the buf CLI, if
+// the caller is the buf CLI.
+func (s *Service) compilerVersion(userAgent string) *pluginpb.Version {
+	if s.CompilerVersion != nil {
+		return s.CompilerVersion
+	}
+
+	if version := BufVersion(userAgent); version != "" {
+		if v, err := plugin.ParseCompilerVersion(version); err == nil {
+			return v
+		}
+	}
+
+	return nil
+}